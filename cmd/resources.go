@@ -5,7 +5,6 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/rancher/wrangler/pkg/kubeconfig"
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
@@ -22,8 +21,7 @@ func init() {
 }
 
 func listResources(cmd *cobra.Command, args []string) error {
-	clientConfig := kubeconfig.GetNonInteractiveClientConfigWithContext(kubeConfig, context)
-	restConfig, err := clientConfig.ClientConfig()
+	restConfig, err := clientConfig().ClientConfig()
 	if err != nil {
 		return err
 	}
@@ -1,14 +1,26 @@
 package cmd
 
 import (
+	"github.com/rancher/wrangler/pkg/kubeconfig"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
 	kubeConfig string
 	context    string
-	namespace  string
+	namespaces []string
+
+	cluster               string
+	user                  string
+	server                string
+	token                 string
+	certificateAuthority  string
+	insecureSkipTLSVerify bool
+	requestTimeout        string
+	impersonate           string
+	impersonateGroups     []string
 )
 
 var rootCmd = &cobra.Command{
@@ -33,5 +45,34 @@ func Execute() error {
 func init() {
 	rootCmd.PersistentFlags().StringVar(&kubeConfig, "kubeconfig", "", "Kube config location")
 	rootCmd.PersistentFlags().StringVar(&context, "context", "", "Kube config context")
-	rootCmd.PersistentFlags().StringVar(&namespace, "namespace", "", "Limit to namespace")
+	rootCmd.PersistentFlags().StringVar(&cluster, "cluster", "", "The name of the kubeconfig cluster to use")
+	rootCmd.PersistentFlags().StringVar(&user, "user", "", "The name of the kubeconfig user to use")
+	rootCmd.PersistentFlags().StringVarP(&server, "server", "s", "", "The address and port of the Kubernetes API server")
+	rootCmd.PersistentFlags().StringVar(&token, "token", "", "Bearer token for authentication to the API server")
+	rootCmd.PersistentFlags().StringVar(&certificateAuthority, "certificate-authority", "", "Path to a cert file for the certificate authority")
+	rootCmd.PersistentFlags().BoolVar(&insecureSkipTLSVerify, "insecure-skip-tls-verify", false, "If true, the server's certificate will not be checked for validity")
+	rootCmd.PersistentFlags().StringVar(&requestTimeout, "request-timeout", "0", "The length of time to wait before giving up on a single server request (e.g. 1s, 2m), 0 for no timeout")
+	rootCmd.PersistentFlags().StringVar(&impersonate, "as", "", "Username to impersonate for the operation")
+	rootCmd.PersistentFlags().StringArrayVar(&impersonateGroups, "as-group", nil, "Group to impersonate for the operation, can be repeated")
+	rootCmd.PersistentFlags().StringSliceVarP(&namespaces, "namespace", "n", nil, "Limit to namespaces, comma separated")
+}
+
+// clientConfig loads the kubeconfig with the connection flags applied on
+// top, the way kubectl does.
+func clientConfig() clientcmd.ClientConfig {
+	overrides := &clientcmd.ConfigOverrides{
+		ClusterDefaults: clientcmd.ClusterDefaults,
+		CurrentContext:  context,
+		Timeout:         requestTimeout,
+	}
+	overrides.Context.Cluster = cluster
+	overrides.Context.AuthInfo = user
+	overrides.ClusterInfo.Server = server
+	overrides.ClusterInfo.CertificateAuthority = certificateAuthority
+	overrides.ClusterInfo.InsecureSkipTLSVerify = insecureSkipTLSVerify
+	overrides.AuthInfo.Token = token
+	overrides.AuthInfo.Impersonate = impersonate
+	overrides.AuthInfo.ImpersonateGroups = impersonateGroups
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(kubeconfig.GetLoadingRules(kubeConfig), overrides)
 }
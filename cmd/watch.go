@@ -1,17 +1,22 @@
 package cmd
 
 import (
+	gocontext "context"
 	"fmt"
 	"io"
+	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/futuretea/kubectl-yadt/pkg/differ"
+	"github.com/futuretea/kubectl-yadt/pkg/output"
+	"github.com/futuretea/kubectl-yadt/pkg/printer"
+	"github.com/futuretea/kubectl-yadt/pkg/terminal"
+	"github.com/futuretea/kubectl-yadt/pkg/throttle"
+	"github.com/futuretea/kubectl-yadt/pkg/ui"
 	"github.com/futuretea/kubectl-yadt/pkg/watcher"
 	"github.com/manifoldco/promptui"
-	"github.com/rancher/wrangler/pkg/clients"
-	"github.com/rancher/wrangler/pkg/generic"
-	"github.com/rancher/wrangler/pkg/kubeconfig"
 	"github.com/rancher/wrangler/pkg/signals"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -21,24 +26,95 @@ import (
 )
 
 var (
-	debug    bool
-	noStatus bool
-	noMeta   bool
+	debug         bool
+	noStatus      bool
+	noMeta        bool
+	outputFormat  string
+	templateText  string
+	templateFile  string
+	timestamp     string
+	contextLines  int
+	maxLines      int
+	maxValue      int
+	fullDiffFile  string
+	tui           bool
+	outputFile    string
+	maxFileSize   int64
+	maxBackups    int
+	tee           bool
+	stripColors   bool
+	colorMode     string
+	themeName     string
+	selector      string
+	fieldSelector string
+	nameRegex     string
+	excludes      []string
+	noExcludes    bool
+	metadataOnly  bool
+	cacheObjects  int
+	cacheMemory   int64
+
+	qps          float32
+	burst        int
+	maxStarts    int
+	backpressure string
+
+	allNamespaces     bool
+	excludeNamespaces []string
+	namespaceSelector string
 )
 
+// statsInterval is how often the differ cache counters are logged with --debug.
+const statsInterval = 30 * time.Second
+
+// queueInterval is how often dropped and coalesced events are reported.
+const queueInterval = 10 * time.Second
+
 var watchCmd = &cobra.Command{
-	Use:   "watch [resources...]",
+	Use:   "watch [resource[/name]...]",
 	Short: "Watch Kubernetes resources and show changes",
 	Long: `Watch Kubernetes resources and print the delta in changes.
-Example: kubectl-yadt watch pods deployments`,
+Example: kubectl-yadt watch pods deployments
+         kubectl-yadt watch 'pods/nginx-*' deployment/api`,
 	RunE: watchRun,
 }
 
 func init() {
 	rootCmd.AddCommand(watchCmd)
 	watchCmd.Flags().BoolVar(&debug, "debug", false, "Enable debug logging")
+	watchCmd.Flags().IntVar(&cacheObjects, "cache-max-objects", 100000, "Maximum number of objects the differ keeps a hash of to skip unchanged updates (0 for no limit)")
+	watchCmd.Flags().Int64Var(&cacheMemory, "cache-max-memory", 64, "Maximum estimated megabytes of hashes the differ keeps (0 for no limit)")
+	watchCmd.Flags().Float32Var(&qps, "qps", 50, "Maximum queries per second to the API server (0 for no client-side limit)")
+	watchCmd.Flags().IntVar(&burst, "burst", 300, "Maximum burst of queries to the API server")
+	watchCmd.Flags().IntVar(&maxStarts, "max-concurrent-starts", 10, "Maximum number of resources doing their initial list at the same time (0 for no limit)")
+	watchCmd.Flags().StringVar(&backpressure, "backpressure", "coalesce", "What to do with events when the output falls behind: block, drop-oldest or coalesce")
+	watchCmd.Flags().StringVarP(&selector, "selector", "l", "", "Label selector to filter on, supports '=', '==', '!=', 'in' and 'notin' (e.g. -l app=nginx)")
+	watchCmd.Flags().StringVar(&fieldSelector, "field-selector", "", "Field selector to filter on, supports '=', '==' and '!=' (e.g. --field-selector spec.nodeName=worker-3)")
+	watchCmd.Flags().StringSliceVar(&excludes, "exclude", nil, "Skip these resources, given as resource, resource.group or *.group, comma separated")
+	watchCmd.Flags().BoolVar(&noExcludes, "no-default-excludes", false, "Also watch "+strings.Join(watcher.DefaultExcludes, ", ")+" when watching every resource or group")
+	watchCmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "Watch objects in all namespaces instead of the namespace of the current context")
+	watchCmd.Flags().StringSliceVar(&excludeNamespaces, "exclude-namespace", nil, "Skip objects in these namespaces, comma separated")
+	watchCmd.Flags().StringVar(&namespaceSelector, "namespace-selector", "", "Only watch objects in namespaces matching this label selector, following namespaces created or relabeled while watching")
+	watchCmd.Flags().StringVar(&nameRegex, "name-regex", "", "Only watch objects whose name matches this regular expression")
 	watchCmd.Flags().BoolVar(&noStatus, "no-status", false, "Ignore status changes")
 	watchCmd.Flags().BoolVar(&noMeta, "no-meta", false, "Ignore metadata changes")
+	watchCmd.Flags().BoolVar(&metadataOnly, "metadata-only", false, "Only watch and diff object metadata (labels, annotations, owners, finalizers, deletion), using far less memory")
+	watchCmd.Flags().StringVar(&timestamp, "timestamp", "time", "Timestamp in the diff header: none|time|rfc3339|relative")
+	watchCmd.Flags().StringVar(&colorMode, "color", "auto", "Colorize output: auto|always|never")
+	watchCmd.Flags().StringVar(&themeName, "theme", "default", "Color theme: "+strings.Join(printer.ThemeNames(), "|"))
+	watchCmd.Flags().BoolVar(&tui, "tui", false, "Show changes in an interactive full screen interface")
+	watchCmd.Flags().StringVarP(&outputFormat, "output", "o", "diff", "Output format: diff|tree|template")
+	watchCmd.Flags().IntVar(&contextLines, "context-lines", 0, "Number of unchanged sibling fields to show around each change with -o tree")
+	watchCmd.Flags().IntVar(&maxLines, "max-lines", 0, "Collapse the changes of an object after this many lines (0 for no limit)")
+	watchCmd.Flags().IntVar(&maxValue, "max-value-bytes", 0, "Truncate changed values larger than this many bytes (0 for no limit)")
+	watchCmd.Flags().StringVar(&fullDiffFile, "full-diff-file", "", "Append the full diff of collapsed or truncated objects to this file")
+	watchCmd.Flags().StringVar(&outputFile, "output-file", "", "Write the output to this file instead of stdout")
+	watchCmd.Flags().Int64Var(&maxFileSize, "output-file-max-size", 100, "Rotate the output file when it grows past this many megabytes (0 to never rotate)")
+	watchCmd.Flags().IntVar(&maxBackups, "output-file-max-backups", 5, "Number of rotated output files to keep")
+	watchCmd.Flags().BoolVar(&tee, "tee", false, "Write the output to both stdout and the output file")
+	watchCmd.Flags().BoolVar(&stripColors, "output-file-strip-colors", false, "Remove colors from the output file, keeping them on stdout")
+	watchCmd.Flags().StringVar(&templateText, "template", "", "Go template to render each change event with -o template")
+	watchCmd.Flags().StringVar(&templateFile, "template-file", "", "File containing the Go template to use with -o template")
 }
 
 func watchRun(cmd *cobra.Command, args []string) error {
@@ -54,72 +130,333 @@ func watchRun(cmd *cobra.Command, args []string) error {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
+	if qps > 0 && burst < 1 {
+		return fmt.Errorf("--burst must be at least 1 when --qps is set")
+	}
+
+	if metadataOnly && noMeta {
+		return fmt.Errorf("--metadata-only and --no-meta are mutually exclusive")
+	}
+
+	var printer differ.Printer
+	var view ui.View
+	var stderr io.Writer = os.Stderr
+	switch {
+	case tui:
+		if outputFile != "" {
+			return fmt.Errorf("--output-file can't be used with --tui")
+		}
+		diffPrinter, err := newTUIPrinter()
+		if err != nil {
+			return err
+		}
+		tuiView := ui.New(diffPrinter)
+		printer, view = tuiView, tuiView
+		// Log output would draw over the screen
+		logrus.SetOutput(io.Discard)
+		stderr = io.Discard
+	case terminal.IsTerminal(os.Stdin) && terminal.IsTerminal(os.Stdout):
+		// Keyboard controls put the terminal in raw mode
+		term := terminal.NewCRLFWriter(os.Stdout)
+		out, err := newOutput(term)
+		if err != nil {
+			return err
+		}
+		p, err := newPrinter(out)
+		if err != nil {
+			return err
+		}
+		stream := ui.NewStream(p, term)
+		printer, view = stream, stream
+		stderr = terminal.NewCRLFWriter(os.Stderr)
+		logrus.SetOutput(stderr)
+	default:
+		out, err := newOutput(os.Stdout)
+		if err != nil {
+			return err
+		}
+		if printer, err = newPrinter(out); err != nil {
+			return err
+		}
+	}
+
 	// Disable klog output
 	klog.SetOutput(io.Discard)
 
 	ctx := signals.SetupSignalContext()
-	restConfig := kubeconfig.GetNonInteractiveClientConfigWithContext(kubeConfig, context)
+	config := clientConfig()
 
 	logrus.WithFields(logrus.Fields{
-		"context":   context,
-		"namespace": namespace,
+		"context":    context,
+		"namespaces": namespaces,
 	}).Info("Creating kubernetes client")
 
-	clients, err := clients.New(restConfig, &generic.FactoryOptions{
-		Namespace: namespace,
-	})
+	restConfig, err := config.ClientConfig()
 	if err != nil {
 		logrus.WithError(err).Debug("Failed to create kubernetes client")
 		return err
 	}
+	throttling := throttle.New(stderr)
+	throttling.Configure(restConfig, qps, burst)
+	go throttling.Run(ctx)
 
-	watcher, err := watcher.New(clients)
+	watcher, err := watcher.New(restConfig)
 	if err != nil {
 		logrus.WithError(err).Debug("Failed to create watcher")
 		return err
 	}
+	watcher.SetMaxConcurrentStarts(maxStarts)
+	if err := watcher.SetBackpressure(backpressure); err != nil {
+		logrus.WithError(err).Debug("Failed to set backpressure policy")
+		return err
+	}
+	if tuiView, ok := view.(*ui.UI); ok {
+		tuiView.SetQueueStats(watcher.QueueStats)
+		tuiView.SetThrottleStats(throttling.Stats)
+	}
+	watcher.SetMetadataOnly(metadataOnly)
+	if ns, _, err := config.Namespace(); err == nil {
+		watcher.SetDefaultNamespace(ns)
+	}
 
 	for _, arg := range args {
 		logrus.WithField("resource", arg).Debug("Adding resource to watch")
-		watcher.MatchName(arg)
+		if err := watcher.MatchName(arg); err != nil {
+			logrus.WithError(err).Debug("Failed to parse resource")
+			return fmt.Errorf("invalid resource %q: %w", arg, err)
+		}
+	}
+	watcher.SetExcludes(excludes)
+	watcher.SetDefaultExcludes(!noExcludes)
+	if err := watcher.SetNameRegex(nameRegex); err != nil {
+		logrus.WithError(err).Debug("Failed to parse name regex")
+		return fmt.Errorf("invalid --name-regex: %w", err)
+	}
+	if allNamespaces && len(namespaces) > 0 {
+		return fmt.Errorf("--all-namespaces and --namespace are mutually exclusive")
+	}
+	if !allNamespaces && len(namespaces) == 0 && len(excludeNamespaces) == 0 && namespaceSelector == "" {
+		// Like kubectl, default to the namespace of the current context
+		if ns, _, err := config.Namespace(); err == nil && ns != "" {
+			namespaces = []string{ns}
+		}
+	}
+	watcher.SetNamespaces(namespaces)
+	watcher.SetExcludeNamespaces(excludeNamespaces)
+	if err := watcher.SetNamespaceSelector(namespaceSelector); err != nil {
+		logrus.WithError(err).Debug("Failed to parse namespace selector")
+		return fmt.Errorf("invalid namespace selector %q: %w", namespaceSelector, err)
+	}
+	if err := watcher.SetLabelSelector(selector); err != nil {
+		logrus.WithError(err).Debug("Failed to parse label selector")
+		return fmt.Errorf("invalid label selector %q: %w", selector, err)
+	}
+	if err := watcher.SetFieldSelector(fieldSelector); err != nil {
+		logrus.WithError(err).Debug("Failed to parse field selector")
+		return fmt.Errorf("invalid field selector %q: %w", fieldSelector, err)
 	}
 
-	differ, err := differ.New(clients)
+	differ, err := differ.New(printer)
 	if err != nil {
 		logrus.WithError(err).Debug("Failed to create differ")
 		return err
 	}
 	differ.SetIgnoreStatus(noStatus)
 	differ.SetIgnoreMeta(noMeta)
+	differ.SetMetadataOnly(metadataOnly)
+	differ.SetCacheLimits(cacheObjects, cacheMemory*1024*1024)
+	if debug {
+		go logStats(ctx, differ)
+	}
 
 	logrus.Debug("Starting to watch resources")
-	objs, err := watcher.Start(ctx)
+	events, err := watcher.Start(ctx)
 	if err != nil {
 		logrus.WithError(err).Debug("Failed to start watcher")
 		return err
 	}
+	printAccess(watcher.Access())
+	go reportQueue(ctx, watcher, stderr)
 
-	go func() {
-		for obj := range objs {
-			if err := differ.Print(obj); err != nil {
-				logrus.WithError(err).Debug("Failed to print diff")
-			}
-		}
-	}()
+	if view != nil {
+		return view.Run(ctx, differ, events)
+	}
 
-	if err := clients.Start(ctx); err != nil {
-		logrus.WithError(err).Debug("Failed to start clients")
-		return err
+	for event := range events {
+		if err := differ.Print(event); err != nil {
+			logrus.WithError(err).Debug("Failed to print diff")
+		}
 	}
 
-	<-ctx.Done()
 	logrus.Debug("Shutting down")
 	return nil
 }
 
+// logStats logs the differ cache counters every statsInterval.
+func logStats(ctx gocontext.Context, d *differ.Differ) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats := d.Stats()
+			logrus.WithFields(logrus.Fields{
+				"entries":   stats.Entries,
+				"bytes":     stats.Bytes,
+				"hits":      stats.Hits,
+				"misses":    stats.Misses,
+				"evictions": stats.Evictions,
+				"unchanged": stats.Unchanged,
+			}).Debug("Differ cache")
+		}
+	}
+}
+
+// reportQueue reports the events dropped or coalesced because the output
+// fell behind, every queueInterval while there are new ones.
+func reportQueue(ctx gocontext.Context, w *watcher.Watcher, out io.Writer) {
+	ticker := time.NewTicker(queueInterval)
+	defer ticker.Stop()
+	var last watcher.QueueStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats := w.QueueStats()
+			if dropped := stats.Dropped - last.Dropped; dropped > 0 {
+				fmt.Fprintf(out, "Warning: dropped %d events because the output fell behind, see --backpressure\n", dropped)
+			}
+			if coalesced := stats.Coalesced - last.Coalesced; coalesced > 0 {
+				fmt.Fprintf(out, "Warning: coalesced %d events because the output fell behind, see --backpressure\n", coalesced)
+			}
+			last = stats
+		}
+	}
+}
+
+// printAccess reports the resources that the preflight found can't be
+// watched, or only in some namespaces.
+func printAccess(access []watcher.Access) {
+	for _, a := range access {
+		switch {
+		case !a.Allowed:
+			fmt.Fprintf(os.Stderr, "Warning: not watching %s, forbidden to list and watch it\n", a.Resource)
+		case a.Namespaces != nil:
+			fmt.Fprintf(os.Stderr, "Warning: watching %s only in namespaces %s\n", a.Resource, strings.Join(a.Namespaces, ", "))
+		}
+	}
+}
+
+// newOutput returns where the printer writes, given the terminal writer.
+func newOutput(term io.Writer) (io.Writer, error) {
+	if outputFile == "" {
+		if tee || stripColors {
+			return nil, fmt.Errorf("--tee and --output-file-strip-colors require --output-file")
+		}
+		return term, nil
+	}
+
+	file, err := output.NewRotatingFile(outputFile, maxFileSize*1024*1024, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer = file
+	if stripColors {
+		out = output.StripColors(out)
+	}
+	if tee {
+		out = io.MultiWriter(term, out)
+	}
+	return out, nil
+}
+
+// newPrinter returns the printer for the selected output format, writing
+// to out.
+func newPrinter(out io.Writer) (differ.Printer, error) {
+	if err := printer.SetColor(colorMode); err != nil {
+		return nil, err
+	}
+
+	switch outputFormat {
+	case "diff", "tree":
+		p, err := newDiffPrinter()
+		if err != nil {
+			return nil, err
+		}
+		p.SetOutput(out)
+		return p, nil
+	case "template", "go-template":
+		var p *printer.TemplatePrinter
+		var err error
+		switch {
+		case templateText != "" && templateFile != "":
+			return nil, fmt.Errorf("--template and --template-file are mutually exclusive")
+		case templateText != "":
+			p, err = printer.NewTemplatePrinter(templateText)
+		case templateFile != "":
+			p, err = printer.NewTemplateFilePrinter(templateFile)
+		default:
+			return nil, fmt.Errorf("-o %s requires --template or --template-file", outputFormat)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.SetOutput(out)
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q, must be one of: diff, tree, template", outputFormat)
+	}
+}
+
+func newDiffPrinter() (*printer.DiffPrinter, error) {
+	if contextLines != 0 && outputFormat != "tree" {
+		return nil, fmt.Errorf("--context-lines requires -o tree")
+	}
+
+	ts, err := printer.ParseTimestamp(timestamp)
+	if err != nil {
+		return nil, err
+	}
+	theme, err := printer.LookupTheme(themeName)
+	if err != nil {
+		return nil, err
+	}
+
+	p := printer.NewPrinter(ts, theme)
+	p.SetTree(outputFormat == "tree")
+	p.SetContext(contextLines)
+	p.SetLimits(maxLines, maxValue)
+	if fullDiffFile != "" {
+		f, err := os.OpenFile(fullDiffFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		p.SetFullDiff(f, fullDiffFile)
+	}
+	return p, nil
+}
+
+// newTUIPrinter returns the printer used to render diffs inside the
+// interactive interface, which only supports the diff and tree outputs.
+func newTUIPrinter() (*printer.DiffPrinter, error) {
+	if !terminal.IsTerminal(os.Stdin) || !terminal.IsTerminal(os.Stdout) {
+		return nil, fmt.Errorf("--tui requires a terminal")
+	}
+	if outputFormat != "diff" && outputFormat != "tree" {
+		return nil, fmt.Errorf("--tui only supports -o diff and -o tree")
+	}
+	if err := printer.SetColor(colorMode); err != nil {
+		return nil, err
+	}
+	return newDiffPrinter()
+}
+
 func selectResource() ([]string, error) {
-	clientConfig := kubeconfig.GetNonInteractiveClientConfigWithContext(kubeConfig, context)
-	restConfig, err := clientConfig.ClientConfig()
+	restConfig, err := clientConfig().ClientConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,107 @@
+package differ
+
+import (
+	"container/list"
+	"sync"
+)
+
+// entryOverhead estimates the bytes of a cache entry besides its key: the
+// entry, its list element and its map slot.
+const entryOverhead = 112
+
+// Stats are the counters of the differ cache. Bytes is an estimate of the
+// memory held by the cache, and Unchanged counts the updates skipped because
+// their hash didn't change.
+type Stats struct {
+	Entries   int
+	Bytes     int64
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Unchanged int64
+}
+
+// cache keeps the hash of the compared fields of objects, evicting the least
+// recently used ones past maxEntries objects or maxBytes estimated bytes.
+type cache struct {
+	lock       sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	entries    *list.List
+	items      map[string]*list.Element
+	stats      Stats
+}
+
+type cacheEntry struct {
+	key  string
+	hash uint64
+}
+
+func newCache() *cache {
+	return &cache{
+		entries: list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func (c *cache) get(key string) (uint64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return 0, false
+	}
+	c.stats.Hits++
+	c.entries.MoveToFront(e)
+	return e.Value.(*cacheEntry).hash, true
+}
+
+func (c *cache) add(key string, hash uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*cacheEntry).hash = hash
+		c.entries.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.entries.PushFront(&cacheEntry{key: key, hash: hash})
+	c.stats.Entries++
+	c.stats.Bytes += entrySize(key)
+
+	for c.entries.Len() > 1 && ((c.maxEntries > 0 && c.stats.Entries > c.maxEntries) ||
+		(c.maxBytes > 0 && c.stats.Bytes > c.maxBytes)) {
+		c.removeLocked(c.entries.Back().Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *cache) unchanged() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stats.Unchanged++
+}
+
+func (c *cache) remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.removeLocked(key)
+}
+
+func (c *cache) removeLocked(key string) {
+	e, ok := c.items[key]
+	if !ok {
+		return
+	}
+	c.entries.Remove(e)
+	delete(c.items, key)
+	c.stats.Entries--
+	c.stats.Bytes -= entrySize(key)
+}
+
+func entrySize(key string) int64 {
+	return int64(len(key)) + entryOverhead
+}
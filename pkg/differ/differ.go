@@ -1,27 +1,25 @@
 package differ
 
 import (
-	"fmt"
+	"hash/fnv"
+	"time"
 
-	"github.com/futuretea/kubectl-yadt/pkg/printer"
-	"github.com/rancher/wrangler/pkg/clients"
+	"github.com/futuretea/kubectl-yadt/pkg/watcher"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime"
 )
 
 type Differ struct {
-	printer      *printer.DiffPrinter
-	clients      *clients.Clients
-	cache        map[string]*unstructured.Unstructured
+	printer      Printer
+	cache        *cache
 	ignoreStatus bool
 	ignoreMeta   bool
+	metadataOnly bool
 }
 
-func New(clients *clients.Clients) (*Differ, error) {
+func New(printer Printer) (*Differ, error) {
 	return &Differ{
-		printer: printer.NewPrinter(true),
-		clients: clients,
-		cache:   make(map[string]*unstructured.Unstructured),
+		printer: printer,
+		cache:   newCache(),
 	}, nil
 }
 
@@ -29,35 +27,111 @@ func (d *Differ) SetIgnoreStatus(ignore bool) {
 	d.ignoreStatus = ignore
 }
 
+// SetIgnoreMeta ignores metadata changes, unless only metadata is diffed.
 func (d *Differ) SetIgnoreMeta(ignore bool) {
-	d.ignoreMeta = ignore
+	d.ignoreMeta = ignore && !d.metadataOnly
 }
 
-func (d *Differ) Print(obj runtime.Object) error {
-	unstructuredObj, ok := obj.(*unstructured.Unstructured)
-	if !ok {
-		data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
-		if err != nil {
-			return err
-		}
-		unstructuredObj = &unstructured.Unstructured{Object: data}
+// SetMetadataOnly diffs the labels, annotations, owners, finalizers and
+// deletion of objects instead of their spec and status.
+func (d *Differ) SetMetadataOnly(metadataOnly bool) {
+	d.metadataOnly = metadataOnly
+	if metadataOnly {
+		d.ignoreMeta = false
 	}
+}
+
+// SetCacheLimits bounds the hashes kept to skip unchanged updates to
+// maxEntries objects and an estimated maxBytes, 0 for no limit. Past them
+// the least recently changed objects are dropped, and their next update is
+// diffed even when unchanged.
+func (d *Differ) SetCacheLimits(maxEntries int, maxBytes int64) {
+	d.cache.lock.Lock()
+	defer d.cache.lock.Unlock()
+	d.cache.maxEntries = maxEntries
+	d.cache.maxBytes = maxBytes
+}
 
-	key := getKey(unstructuredObj)
-	oldObj := d.cache[key]
+// Stats returns the counters of the cache.
+func (d *Differ) Stats() Stats {
+	d.cache.lock.Lock()
+	defer d.cache.lock.Unlock()
+	return d.cache.stats
+}
+
+func (d *Differ) IgnoreStatus() bool {
+	return d.ignoreStatus
+}
 
-	if oldObj == nil {
-		oldObj, _ = d.getCurrentObject(unstructuredObj)
-		if oldObj == nil {
-			oldObj = newEmptyObject(unstructuredObj)
+func (d *Differ) IgnoreMeta() bool {
+	return d.ignoreMeta
+}
+
+func (d *Differ) MetadataOnly() bool {
+	return d.metadataOnly
+}
+
+// Print diffs the object of event against the informer's previous version,
+// and prints the changes. Listed objects are not printed.
+func (d *Differ) Print(watched watcher.Event) error {
+	obj := watched.Object
+	key := watcher.Key(obj)
+
+	if watched.Type == watcher.Deleted {
+		d.cache.remove(key)
+	} else {
+		// Skip the copies and the diff when the compared fields are unchanged
+		hash := hashValue(fnv.New64a(), d.filteredFields(obj)).Sum64()
+		previousHash, known := d.cache.get(key)
+		d.cache.add(key, hash)
+		if watched.Type == watcher.Listed || (watched.Type == watcher.Updated && known && hash == previousHash) {
+			if watched.Type == watcher.Updated {
+				d.cache.unchanged()
+			}
+			return nil
 		}
 	}
 
-	d.cache[key] = unstructuredObj.DeepCopy()
+	// Objects from the informer are shared, copy them before filtering
+	var oldObj *unstructured.Unstructured
+	if watched.Old != nil {
+		oldObj = watched.Old.DeepCopy()
+	}
+	newObj := obj.DeepCopy()
+
+	eventType := EventModified
+	switch {
+	case watched.Type == watcher.Deleted:
+		eventType = EventDeleted
+		oldObj = newObj
+		newObj = newEmptyObject(obj)
+	case oldObj == nil:
+		oldObj = newEmptyObject(obj)
+		eventType = EventAdded
+	case obj.GetDeletionTimestamp() != nil:
+		eventType = EventDeleting
+	}
+
+	// Record metadata before it is filtered out below
+	event := &Event{
+		Type:               eventType,
+		Kind:               obj.GetKind(),
+		APIVersion:         obj.GetAPIVersion(),
+		Namespace:          obj.GetNamespace(),
+		Name:               obj.GetName(),
+		UID:                string(obj.GetUID()),
+		OldResourceVersion: oldObj.GetResourceVersion(),
+		ResourceVersion:    obj.GetResourceVersion(),
+		Generation:         obj.GetGeneration(),
+		Time:               time.Now(),
+	}
+	if observed, ok, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration"); ok {
+		event.ObservedGeneration = &observed
+	}
 
 	if d.ignoreStatus {
 		delete(oldObj.Object, "status")
-		delete(unstructuredObj.Object, "status")
+		delete(newObj.Object, "status")
 	}
 
 	if d.ignoreMeta {
@@ -69,38 +143,68 @@ func (d *Differ) Print(obj runtime.Object) error {
 			}
 			oldObj.Object["metadata"] = cleanMeta
 		}
-		if meta, ok := unstructuredObj.Object["metadata"].(map[string]interface{}); ok {
+		if meta, ok := newObj.Object["metadata"].(map[string]interface{}); ok {
 			cleanMeta := map[string]interface{}{
 				"name":      meta["name"],
 				"namespace": meta["namespace"],
 			}
-			unstructuredObj.Object["metadata"] = cleanMeta
+			newObj.Object["metadata"] = cleanMeta
 		}
 	}
 
-	return d.printer.Print(oldObj, unstructuredObj)
+	event.Changes = diff("", nil, d.compareFields(oldObj), d.compareFields(newObj))
+	if len(event.Changes) == 0 {
+		return nil
+	}
+
+	event.Old = oldObj
+	event.New = newObj
+	return d.printer.Print(event)
 }
 
-func (d *Differ) getCurrentObject(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
-	result, err := d.clients.Dynamic.Get(obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName())
-	if err != nil {
-		return nil, err
-	}
+// metadataFields are the metadata fields diffed in metadata only mode.
+var metadataFields = []string{
+	"labels",
+	"annotations",
+	"ownerReferences",
+	"finalizers",
+	"deletionTimestamp",
+	"deletionGracePeriodSeconds",
+}
 
-	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(result)
-	if err != nil {
-		return nil, err
+// filteredFields returns the fields of obj that are diffed with the current
+// settings, without modifying obj.
+func (d *Differ) filteredFields(obj *unstructured.Unstructured) map[string]interface{} {
+	fields := d.compareFields(obj)
+	if d.ignoreStatus {
+		delete(fields, "status")
 	}
-
-	return &unstructured.Unstructured{Object: data}, nil
+	return fields
 }
 
-func getKey(obj *unstructured.Unstructured) string {
-	return fmt.Sprintf("%s/%s/%s/%s",
-		obj.GetAPIVersion(),
-		obj.GetKind(),
-		obj.GetNamespace(),
-		obj.GetName())
+// compareFields returns the top level fields of obj that are diffed.
+func (d *Differ) compareFields(obj *unstructured.Unstructured) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if d.metadataOnly {
+		meta, _ := obj.Object["metadata"].(map[string]interface{})
+		metadata := make(map[string]interface{})
+		for _, name := range metadataFields {
+			if val, ok := meta[name]; ok {
+				metadata[name] = val
+			}
+		}
+		if len(metadata) > 0 {
+			fields["metadata"] = metadata
+		}
+		return fields
+	}
+
+	for _, name := range []string{"spec", "status"} {
+		if val, ok := obj.Object[name]; ok {
+			fields[name] = val
+		}
+	}
+	return fields
 }
 
 func newEmptyObject(obj *unstructured.Unstructured) *unstructured.Unstructured {
@@ -0,0 +1,32 @@
+package differ
+
+import "testing"
+
+func TestSetIgnoreMetaWithMetadataOnly(t *testing.T) {
+	tests := []struct {
+		name         string
+		metadataOnly bool
+		ignoreFirst  bool
+		want         bool
+	}{
+		{"ignored", false, false, true},
+		{"not ignored with metadata only", true, false, false},
+		{"cleared by metadata only", true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := New(nil)
+			if tt.ignoreFirst {
+				d.SetIgnoreMeta(true)
+				d.SetMetadataOnly(tt.metadataOnly)
+			} else {
+				d.SetMetadataOnly(tt.metadataOnly)
+				d.SetIgnoreMeta(true)
+			}
+			if got := d.IgnoreMeta(); got != tt.want {
+				t.Errorf("IgnoreMeta() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
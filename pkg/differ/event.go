@@ -0,0 +1,147 @@
+package differ
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type ChangeType string
+
+const (
+	ChangeAdded    ChangeType = "added"
+	ChangeRemoved  ChangeType = "removed"
+	ChangeModified ChangeType = "modified"
+)
+
+type EventType string
+
+const (
+	EventAdded    EventType = "added"
+	EventModified EventType = "modified"
+	EventDeleting EventType = "deleting"
+	EventDeleted  EventType = "deleted"
+)
+
+// Change is a single changed field. Old is nil for added fields and New is
+// nil for removed fields.
+type Change struct {
+	Path string      `json:"path"`
+	Type ChangeType  `json:"type"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+	// Segments is Path split into map keys (string) and list indexes (int),
+	// since keys such as annotation names may themselves contain dots.
+	Segments []interface{} `json:"-"`
+}
+
+// Event describes all the changes seen for an object in one update.
+type Event struct {
+	Type               EventType                  `json:"type"`
+	Kind               string                     `json:"kind"`
+	APIVersion         string                     `json:"apiVersion"`
+	Namespace          string                     `json:"namespace,omitempty"`
+	Name               string                     `json:"name"`
+	UID                string                     `json:"uid,omitempty"`
+	OldResourceVersion string                     `json:"oldResourceVersion,omitempty"`
+	ResourceVersion    string                     `json:"resourceVersion,omitempty"`
+	Generation         int64                      `json:"generation,omitempty"`
+	ObservedGeneration *int64                     `json:"observedGeneration,omitempty"`
+	Time               time.Time                  `json:"time"`
+	Old                *unstructured.Unstructured `json:"old,omitempty"`
+	New                *unstructured.Unstructured `json:"new,omitempty"`
+	Changes            []Change                   `json:"changes"`
+}
+
+// Printer renders the events produced by the differ.
+type Printer interface {
+	Print(event *Event) error
+}
+
+// diff returns the changes between old and new, walking maps and slices
+// down to the first value that differs.
+func diff(path string, segments []interface{}, old, new interface{}) []Change {
+	switch {
+	case old == nil && new == nil:
+		return nil
+	case old == nil:
+		return []Change{{Path: path, Type: ChangeAdded, New: new, Segments: segments}}
+	case new == nil:
+		return []Change{{Path: path, Type: ChangeRemoved, Old: old, Segments: segments}}
+	}
+
+	switch oldVal := old.(type) {
+	case map[string]interface{}:
+		if newVal, ok := new.(map[string]interface{}); ok {
+			return diffMap(path, segments, oldVal, newVal)
+		}
+	case []interface{}:
+		if newVal, ok := new.([]interface{}); ok {
+			return diffSlice(path, segments, oldVal, newVal)
+		}
+	default:
+		if old == new {
+			return nil
+		}
+	}
+
+	return []Change{{Path: path, Type: ChangeModified, Old: old, New: new, Segments: segments}}
+}
+
+// appendSegment returns a copy of segments with segment appended, so that
+// sibling changes never share a backing array.
+func appendSegment(segments []interface{}, segment interface{}) []interface{} {
+	result := make([]interface{}, len(segments), len(segments)+1)
+	copy(result, segments)
+	return append(result, segment)
+}
+
+func diffMap(path string, segments []interface{}, old, new map[string]interface{}) []Change {
+	// Get all keys
+	keys := make(map[string]bool)
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+
+	// Sort keys for consistent output
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	var changes []Change
+	for _, k := range sortedKeys {
+		newPath := k
+		if path != "" {
+			newPath = path + "." + k
+		}
+		changes = append(changes, diff(newPath, appendSegment(segments, k), old[k], new[k])...)
+	}
+	return changes
+}
+
+func diffSlice(path string, segments []interface{}, old, new []interface{}) []Change {
+	maxLen := len(old)
+	if len(new) > maxLen {
+		maxLen = len(new)
+	}
+
+	var changes []Change
+	for i := 0; i < maxLen; i++ {
+		var oldVal, newVal interface{}
+		if i < len(old) {
+			oldVal = old[i]
+		}
+		if i < len(new) {
+			newVal = new[i]
+		}
+		changes = append(changes, diff(fmt.Sprintf("%s[%d]", path, i), appendSegment(segments, i), oldVal, newVal)...)
+	}
+	return changes
+}
@@ -0,0 +1,65 @@
+package differ
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math"
+	"sort"
+)
+
+// hashValue writes a canonical encoding of the unstructured value v to h,
+// with map keys sorted and every value tagged with its type, and returns h.
+func hashValue(h hash.Hash64, v interface{}) hash.Hash64 {
+	var buf [8]byte
+	writeLen := func(n int) {
+		binary.LittleEndian.PutUint64(buf[:], uint64(n))
+		h.Write(buf[:])
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		h.Write([]byte{'m'})
+		writeLen(len(keys))
+		for _, key := range keys {
+			writeLen(len(key))
+			h.Write([]byte(key))
+			hashValue(h, v[key])
+		}
+	case []interface{}:
+		h.Write([]byte{'l'})
+		writeLen(len(v))
+		for _, val := range v {
+			hashValue(h, val)
+		}
+	case string:
+		h.Write([]byte{'s'})
+		writeLen(len(v))
+		h.Write([]byte(v))
+	case int64:
+		h.Write([]byte{'i'})
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		h.Write(buf[:])
+	case float64:
+		h.Write([]byte{'f'})
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+		h.Write(buf[:])
+	case bool:
+		if v {
+			h.Write([]byte{'t'})
+		} else {
+			h.Write([]byte{'b'})
+		}
+	case nil:
+		h.Write([]byte{'n'})
+	default:
+		h.Write([]byte{'?'})
+		fmt.Fprint(h, v)
+	}
+	return h
+}
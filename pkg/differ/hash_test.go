@@ -0,0 +1,60 @@
+package differ
+
+import (
+	"hash/fnv"
+	"testing"
+)
+
+func hashOf(v interface{}) uint64 {
+	return hashValue(fnv.New64a(), v).Sum64()
+}
+
+func TestHashValueEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"scalars", "nginx", "nginx"},
+		{"maps built in a different order",
+			map[string]interface{}{"a": int64(1), "b": "x", "c": []interface{}{true, nil}},
+			map[string]interface{}{"c": []interface{}{true, nil}, "b": "x", "a": int64(1)}},
+		{"nested", map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}},
+			map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if hashOf(tt.a) != hashOf(tt.b) {
+				t.Errorf("hash of %v and %v differ", tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestHashValueDiffers(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"int and float", int64(1), float64(1)},
+		{"int and string", int64(1), "1"},
+		{"true and false", true, false},
+		{"nil and empty string", nil, ""},
+		{"empty map and empty list", map[string]interface{}{}, []interface{}{}},
+		{"nil value and missing key", map[string]interface{}{"a": nil}, map[string]interface{}{}},
+		{"key and value boundary", map[string]interface{}{"a": "bc"}, map[string]interface{}{"ab": "c"}},
+		{"list element boundary", []interface{}{"a", "b"}, []interface{}{"ab"}},
+		{"list order", []interface{}{"a", "b"}, []interface{}{"b", "a"}},
+		{"nested list and flat list", []interface{}{[]interface{}{"a"}, "b"}, []interface{}{"a", []interface{}{"b"}}},
+		{"changed nested value", map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}},
+			map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(4)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if hashOf(tt.a) == hashOf(tt.b) {
+				t.Errorf("hash of %v and %v are equal", tt.a, tt.b)
+			}
+		})
+	}
+}
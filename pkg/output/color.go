@@ -0,0 +1,24 @@
+package output
+
+import (
+	"io"
+	"regexp"
+)
+
+var colorSequence = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// stripColors removes the color escape sequences written to it.
+type stripColors struct {
+	w io.Writer
+}
+
+func StripColors(w io.Writer) io.Writer {
+	return &stripColors{w: w}
+}
+
+func (s *stripColors) Write(b []byte) (int, error) {
+	if _, err := s.w.Write(colorSequence.ReplaceAll(b, nil)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
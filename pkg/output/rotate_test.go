@@ -0,0 +1,131 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSize    int64
+		maxBackups int
+		writes     []string
+		want       map[string]string // file suffix to content, "" for the file itself
+		missing    []string
+	}{
+		{
+			name:       "no rotation below the limit",
+			maxSize:    10,
+			maxBackups: 2,
+			writes:     []string{"aaaa", "bbbb"},
+			want:       map[string]string{"": "aaaabbbb"},
+			missing:    []string{".1"},
+		},
+		{
+			name:       "rotates past the limit",
+			maxSize:    10,
+			maxBackups: 2,
+			writes:     []string{"aaaaaaaaaa", "bbbb"},
+			want:       map[string]string{"": "bbbb", ".1": "aaaaaaaaaa"},
+			missing:    []string{".2"},
+		},
+		{
+			name:       "shifts the backups",
+			maxSize:    10,
+			maxBackups: 2,
+			writes:     []string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc"},
+			want:       map[string]string{"": "cccccccccc", ".1": "bbbbbbbbbb", ".2": "aaaaaaaaaa"},
+		},
+		{
+			name:       "drops the oldest backup",
+			maxSize:    10,
+			maxBackups: 2,
+			writes:     []string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc", "dd"},
+			want:       map[string]string{"": "dd", ".1": "cccccccccc", ".2": "bbbbbbbbbb"},
+			missing:    []string{".3"},
+		},
+		{
+			name:       "no backups",
+			maxSize:    10,
+			maxBackups: 0,
+			writes:     []string{"aaaaaaaaaa", "bbbb"},
+			want:       map[string]string{"": "bbbb"},
+			missing:    []string{".1"},
+		},
+		{
+			name:       "oversized write to an empty file",
+			maxSize:    4,
+			maxBackups: 1,
+			writes:     []string{"aaaaaaaaaa"},
+			want:       map[string]string{"": "aaaaaaaaaa"},
+			missing:    []string{".1"},
+		},
+		{
+			name:       "no limit",
+			maxSize:    0,
+			maxBackups: 1,
+			writes:     []string{"aaaaaaaaaa", "bbbbbbbbbb"},
+			want:       map[string]string{"": "aaaaaaaaaabbbbbbbbbb"},
+			missing:    []string{".1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "watch.log")
+			f, err := NewRotatingFile(path, tt.maxSize, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.writes {
+				if _, err := f.Write([]byte(w)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for suffix, want := range tt.want {
+				got, err := os.ReadFile(path + suffix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("watch.log%s = %q, want %q", suffix, got, want)
+				}
+			}
+			for _, suffix := range tt.missing {
+				if _, err := os.Stat(path + suffix); !os.IsNotExist(err) {
+					t.Errorf("watch.log%s exists", suffix)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watch.log")
+	if err := os.WriteFile(path, []byte("aaaaaaaa"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The existing content counts towards the limit
+	f, err := NewRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("bbbb")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if got, _ := os.ReadFile(path); string(got) != "bbbb" {
+		t.Errorf("watch.log = %q, want %q", got, "bbbb")
+	}
+	if got, _ := os.ReadFile(path + ".1"); string(got) != "aaaaaaaa" {
+		t.Errorf("watch.log.1 = %q, want %q", got, "aaaaaaaa")
+	}
+}
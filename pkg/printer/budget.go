@@ -0,0 +1,73 @@
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// lineLimiter passes through the first max lines written to it and drops
+// the rest, counting them.
+type lineLimiter struct {
+	w       io.Writer
+	max     int
+	lines   int
+	dropped int
+}
+
+func (l *lineLimiter) Write(b []byte) (int, error) {
+	if l.full() {
+		l.dropped += bytes.Count(b, []byte("\n"))
+		return len(b), nil
+	}
+	l.lines += bytes.Count(b, []byte("\n"))
+	return l.w.Write(b)
+}
+
+func (l *lineLimiter) full() bool {
+	return l.max > 0 && l.lines >= l.max
+}
+
+// truncateLines keeps the lines of a value that fit in maxValueBytes and
+// replaces the rest with a note of how much was left out.
+func (p *DiffPrinter) truncateLines(lines []string) []string {
+	if p.maxValueBytes <= 0 {
+		return lines
+	}
+
+	total := 0
+	for _, line := range lines {
+		total += len(line) + 1
+	}
+	if total <= p.maxValueBytes {
+		return lines
+	}
+
+	p.truncated = true
+	size := 0
+	for i, line := range lines {
+		if size+len(line)+1 > p.maxValueBytes {
+			if i == 0 {
+				return []string{p.truncateScalar(line)}
+			}
+			return append(lines[:i:i], fmt.Sprintf("… %d more bytes", total-size))
+		}
+		size += len(line) + 1
+	}
+	return lines
+}
+
+// truncateScalar cuts s to maxValueBytes on a rune boundary.
+func (p *DiffPrinter) truncateScalar(s string) string {
+	if p.maxValueBytes <= 0 || len(s) <= p.maxValueBytes {
+		return s
+	}
+
+	p.truncated = true
+	cut := p.maxValueBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s… %d more bytes", s[:cut], len(s)-cut)
+}
@@ -3,199 +3,230 @@ package printer
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
+	"io"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/fatih/color"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"github.com/futuretea/kubectl-yadt/pkg/differ"
 )
 
+// Timestamp selects how the time of an event is shown in the diff header.
+type Timestamp string
+
+const (
+	TimestampNone     Timestamp = "none"
+	TimestampTime     Timestamp = "time"
+	TimestampRFC3339  Timestamp = "rfc3339"
+	TimestampRelative Timestamp = "relative"
+)
+
+// ParseTimestamp validates a --timestamp value.
+func ParseTimestamp(s string) (Timestamp, error) {
+	switch t := Timestamp(s); t {
+	case TimestampNone, TimestampTime, TimestampRFC3339, TimestampRelative:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown timestamp format %q, must be one of: none, time, rfc3339, relative", s)
+}
+
 type DiffPrinter struct {
-	lastPrintTime time.Time
-	showTimestamp bool
-	// Color functions
-	added    func(a ...interface{}) string
-	removed  func(a ...interface{}) string
-	modified func(a ...interface{}) string
-	header   func(a ...interface{}) string
+	out       io.Writer
+	startTime time.Time
+	timestamp Timestamp
+	theme     Theme
+	tree      bool
+	context   int
+	// Output budget, zero means unlimited
+	maxLines      int
+	maxValueBytes int
+	fullDiff      io.Writer
+	fullDiffName  string
+
+	// State of the event being printed
+	w         *lineLimiter
+	skipped   int
+	truncated bool
 }
 
-func NewPrinter(showTimestamp bool) *DiffPrinter {
+func NewPrinter(timestamp Timestamp, theme Theme) *DiffPrinter {
 	return &DiffPrinter{
-		showTimestamp: showTimestamp,
-		added:         color.New(color.FgGreen).SprintFunc(),
-		removed:       color.New(color.FgRed).SprintFunc(),
-		modified:      color.New(color.FgYellow).SprintFunc(),
-		header:        color.New(color.FgCyan).SprintFunc(),
+		out:       os.Stdout,
+		startTime: time.Now(),
+		timestamp: timestamp,
+		theme:     theme,
 	}
 }
 
-func (p *DiffPrinter) Print(oldObj, newObj *unstructured.Unstructured) error {
-	// Deep copy objects to avoid modifying originals
-	oldData := oldObj.DeepCopy()
-	newData := newObj.DeepCopy()
+// SetOutput sets where the diff is written, stdout by default.
+func (p *DiffPrinter) SetOutput(w io.Writer) {
+	p.out = w
+}
 
-	// Get fields for comparison
-	oldFields := make(map[string]interface{})
-	newFields := make(map[string]interface{})
+// SetTree switches between one line per changed path and a nested YAML tree
+// of the changed branches.
+func (p *DiffPrinter) SetTree(tree bool) {
+	p.tree = tree
+}
 
-	// Always include spec
-	if spec, ok := oldData.Object["spec"]; ok {
-		oldFields["spec"] = spec
-	}
-	if spec, ok := newData.Object["spec"]; ok {
-		newFields["spec"] = spec
-	}
+// SetContext sets how many unchanged sibling fields are shown around each
+// change in the tree output.
+func (p *DiffPrinter) SetContext(lines int) {
+	p.context = lines
+}
 
-	// Include status if not ignored
-	if status, ok := oldData.Object["status"]; ok {
-		oldFields["status"] = status
-	}
-	if status, ok := newData.Object["status"]; ok {
-		newFields["status"] = status
-	}
+// SetLimits caps the number of lines printed for the changes of one object
+// and the size of a single printed value. Zero disables a limit.
+func (p *DiffPrinter) SetLimits(maxLines, maxValueBytes int) {
+	p.maxLines = maxLines
+	p.maxValueBytes = maxValueBytes
+}
 
-	// Compare filtered objects and check if there are any differences
-	if p.areObjectsEqual(oldFields, newFields) {
-		return nil
-	}
+// SetFullDiff sets where the complete, uncolored diff of objects that were
+// collapsed by the limits is written. name is shown to point the reader there.
+func (p *DiffPrinter) SetFullDiff(w io.Writer, name string) {
+	p.fullDiff = w
+	p.fullDiffName = name
+}
 
+func (p *DiffPrinter) Print(event *differ.Event) error {
 	// Format header
-	name := newObj.GetName()
-	namespace := newObj.GetNamespace()
-	if namespace != "" {
-		name = namespace + "/" + name
+	name := event.Name
+	if event.Namespace != "" {
+		name = event.Namespace + "/" + name
 	}
 
-	resource := newObj.GetKind()
-	if group := newObj.GetAPIVersion(); group != "" {
+	resource := event.Kind
+	if group := event.APIVersion; group != "" {
 		resource = fmt.Sprintf("%s.%s", strings.ToLower(resource), group)
 	}
 
 	// Print header
-	timestamp := ""
-	if p.showTimestamp {
-		now := time.Now()
-		if now.Sub(p.lastPrintTime) > time.Second {
-			timestamp = now.Format("15:04:05 ")
-			p.lastPrintTime = now
-		}
+	timestamp := p.formatTime(event.Time)
+	if timestamp != "" {
+		timestamp += " "
 	}
 
-	fmt.Printf("%s%s\n", timestamp, p.header(fmt.Sprintf("diff %s %s", resource, name)))
-	fmt.Printf("%s\n", p.header(strings.Repeat("-", 80)))
+	fmt.Fprintf(p.out, "%s%s\n", timestamp, p.theme.Header(fmt.Sprintf("diff %s %s", resource, name)))
+	fmt.Fprintf(p.out, "%s\n", p.theme.Header(formatMeta(event)))
+	fmt.Fprintf(p.out, "%s\n", p.theme.Header(strings.Repeat("-", 80)))
 
-	// For first time objects (no old version)
-	if oldObj == nil {
-		fmt.Printf("%s\n", p.added("+ New Resource"))
-		// Print spec if exists
-		if spec, ok := newObj.Object["spec"]; ok {
-			p.printSection("spec", spec, true)
-		}
-		// Print status if exists
-		if status, ok := newObj.Object["status"]; ok {
-			p.printSection("status", status, true)
-		}
-		fmt.Println()
-		return nil
+	// Print the diff
+	p.w = &lineLimiter{w: p.out, max: p.maxLines}
+	p.skipped = 0
+	p.truncated = false
+	if p.tree {
+		p.printTree(newTree(event.Changes), "", event.Old.Object, event.New.Object)
+	} else {
+		p.printChanges(event.Changes)
 	}
 
-	// Print the diff
-	p.printDiff("", oldFields, newFields, "")
-	fmt.Println()
-	return nil
+	if p.skipped > 0 || p.w.dropped > 0 || p.truncated {
+		if err := p.printCollapsed(event); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(p.out)
+	return err
 }
 
-func (p *DiffPrinter) printDiff(path string, old, new interface{}, indent string) {
-	switch {
-	case old == nil && new == nil:
-		return
-	case old == nil:
-		p.printValue(path, new, indent, true)
-	case new == nil:
-		p.printValue(path, old, indent, false)
-	default:
-		switch oldVal := old.(type) {
-		case map[string]interface{}:
-			if newVal, ok := new.(map[string]interface{}); ok {
-				p.printMapDiff(path, oldVal, newVal, indent)
-			} else {
-				p.printValue(path, old, indent, false)
-				p.printValue(path, new, indent, true)
-			}
-		case []interface{}:
-			if newVal, ok := new.([]interface{}); ok {
-				p.printSliceDiff(path, oldVal, newVal, indent)
-			} else {
-				p.printValue(path, old, indent, false)
-				p.printValue(path, new, indent, true)
-			}
+func (p *DiffPrinter) printChanges(changes []differ.Change) {
+	for _, change := range changes {
+		if p.w.full() {
+			p.skipped++
+			continue
+		}
+
+		// Nest list elements the same way they are nested in the object
+		indent := strings.Repeat("  ", strings.Count(change.Path, "["))
+		switch change.Type {
+		case differ.ChangeAdded:
+			p.printValue(change.Path, change.New, indent, true)
+		case differ.ChangeRemoved:
+			p.printValue(change.Path, change.Old, indent, false)
 		default:
-			if old != new {
-				p.printValue(path, old, indent, false)
-				p.printValue(path, new, indent, true)
+			if p.theme.InlineModified && isScalar(change.Old) && isScalar(change.New) {
+				fmt.Fprintf(p.w, "%s%s%s: %s → %s\n", p.theme.Modified("~"), indent, change.Path,
+					p.truncateScalar(fmt.Sprint(change.Old)), p.truncateScalar(fmt.Sprint(change.New)))
+				continue
 			}
+			p.printValue(change.Path, change.Old, indent, false)
+			p.printValue(change.Path, change.New, indent, true)
 		}
 	}
 }
 
-func (p *DiffPrinter) printMapDiff(path string, old, new map[string]interface{}, indent string) {
-	// Get all keys
-	keys := make(map[string]bool)
-	for k := range old {
-		keys[k] = true
+// printCollapsed tells how much of the diff was left out, and writes the
+// whole diff to the full diff file if there is one.
+func (p *DiffPrinter) printCollapsed(event *differ.Event) error {
+	var parts []string
+	switch {
+	case p.w.dropped == 1:
+		parts = append(parts, "1 more line")
+	case p.w.dropped > 1:
+		parts = append(parts, fmt.Sprintf("%d more lines", p.w.dropped))
 	}
-	for k := range new {
-		keys[k] = true
+	switch {
+	case p.skipped == 1:
+		parts = append(parts, "1 more changed field")
+	case p.skipped > 1:
+		parts = append(parts, fmt.Sprintf("%d more changed fields", p.skipped))
 	}
-
-	// Sort keys for consistent output
-	sortedKeys := make([]string, 0, len(keys))
-	for k := range keys {
-		sortedKeys = append(sortedKeys, k)
+	if len(parts) == 0 {
+		parts = append(parts, "values truncated")
 	}
-	sort.Strings(sortedKeys)
-
-	// Print diff for each key
-	for _, k := range sortedKeys {
-		oldVal, oldOk := old[k]
-		newVal, newOk := new[k]
-
-		newPath := k
-		if path != "" {
-			newPath = path + "." + k
-		}
-
-		switch {
-		case !oldOk:
-			p.printDiff(newPath, nil, newVal, indent)
-		case !newOk:
-			p.printDiff(newPath, oldVal, nil, indent)
-		default:
-			p.printDiff(newPath, oldVal, newVal, indent)
+	msg := "… " + strings.Join(parts, ", ")
+
+	if p.fullDiff != nil {
+		full := *p
+		full.out = p.fullDiff
+		full.theme = plainTheme(p.theme)
+		full.maxLines = 0
+		full.maxValueBytes = 0
+		full.fullDiff = nil
+		if err := full.Print(event); err != nil {
+			return err
 		}
+		msg += fmt.Sprintf(" (full diff in %s)", p.fullDiffName)
 	}
+
+	_, err := fmt.Fprintf(p.out, "%s\n", p.theme.Header(msg))
+	return err
 }
 
-func (p *DiffPrinter) printSliceDiff(path string, old, new []interface{}, indent string) {
-	maxLen := len(old)
-	if len(new) > maxLen {
-		maxLen = len(new)
+func (p *DiffPrinter) formatTime(t time.Time) string {
+	switch p.timestamp {
+	case TimestampTime:
+		return t.Format("15:04:05")
+	case TimestampRFC3339:
+		return t.Format(time.RFC3339)
+	case TimestampRelative:
+		return "+" + t.Sub(p.startTime).Round(time.Millisecond).String()
+	default:
+		return ""
 	}
+}
 
-	for i := 0; i < maxLen; i++ {
-		var oldVal, newVal interface{}
-		if i < len(old) {
-			oldVal = old[i]
-		}
-		if i < len(new) {
-			newVal = new[i]
-		}
-
-		newPath := fmt.Sprintf("%s[%d]", path, i)
-		p.printDiff(newPath, oldVal, newVal, indent+"  ")
+// formatMeta describes the event type and the object metadata that tells
+// which revision of the object the diff belongs to.
+func formatMeta(event *differ.Event) string {
+	fields := []string{string(event.Type)}
+	if event.UID != "" {
+		fields = append(fields, "uid="+event.UID)
+	}
+	switch {
+	case event.OldResourceVersion != "" && event.OldResourceVersion != event.ResourceVersion:
+		fields = append(fields, fmt.Sprintf("resourceVersion=%s→%s", event.OldResourceVersion, event.ResourceVersion))
+	case event.ResourceVersion != "":
+		fields = append(fields, "resourceVersion="+event.ResourceVersion)
 	}
+	if event.Generation != 0 {
+		fields = append(fields, fmt.Sprintf("generation=%d", event.Generation))
+	}
+	if event.ObservedGeneration != nil {
+		fields = append(fields, fmt.Sprintf("observedGeneration=%d", *event.ObservedGeneration))
+	}
+	return strings.Join(fields, " ")
 }
 
 func (p *DiffPrinter) printValue(path string, val interface{}, indent string, isAdd bool) {
@@ -207,123 +238,44 @@ func (p *DiffPrinter) printValue(path string, val interface{}, indent string, is
 	var colorFunc func(a ...interface{}) string
 	if isAdd {
 		prefix = "+"
-		colorFunc = p.added
+		colorFunc = p.theme.Added
 	} else {
 		prefix = "-"
-		colorFunc = p.removed
+		colorFunc = p.theme.Removed
 	}
 
 	switch v := val.(type) {
 	case map[string]interface{}:
-		if path == "" {
-			// This is the root object, print its fields directly
-			for k, fieldVal := range v {
-				p.printDiff(k, nil, fieldVal, indent)
-			}
-		} else {
-			b, _ := json.MarshalIndent(v, indent, "  ")
-			lines := strings.Split(string(b), "\n")
-			for _, line := range lines {
-				if line == "{" || line == "}" {
-					continue
-				}
-				fmt.Printf("%s%s%s\n", colorFunc(prefix), indent, line)
+		b, _ := json.MarshalIndent(v, indent, "  ")
+		lines := strings.Split(string(b), "\n")
+		for _, line := range p.truncateLines(lines) {
+			if line == "{" || line == "}" {
+				continue
 			}
+			fmt.Fprintf(p.w, "%s%s%s\n", colorFunc(prefix), indent, line)
 		}
 	case []interface{}:
 		b, _ := json.MarshalIndent(v, indent, "  ")
 		lines := strings.Split(string(b), "\n")
-		for _, line := range lines {
+		for _, line := range p.truncateLines(lines) {
 			if line == "[" || line == "]" {
 				continue
 			}
-			fmt.Printf("%s%s%s\n", colorFunc(prefix), indent, line)
+			fmt.Fprintf(p.w, "%s%s%s\n", colorFunc(prefix), indent, line)
 		}
 	default:
 		if path != "" {
-			fmt.Printf("%s%s%s: %v\n", colorFunc(prefix), indent, path, v)
+			fmt.Fprintf(p.w, "%s%s%s: %s\n", colorFunc(prefix), indent, path, p.truncateScalar(fmt.Sprint(v)))
 		} else {
-			fmt.Printf("%s%s%v\n", colorFunc(prefix), indent, v)
-		}
-	}
-}
-
-func (p *DiffPrinter) printSection(name string, val interface{}, isAdd bool) {
-	prefix := "+"
-	if !isAdd {
-		prefix = "-"
-	}
-	colorFunc := p.added
-	if !isAdd {
-		colorFunc = p.removed
-	}
-
-	fmt.Printf("%s %s:\n", colorFunc(prefix), name)
-	if m, ok := val.(map[string]interface{}); ok {
-		b, _ := json.MarshalIndent(m, "  ", "  ")
-		lines := strings.Split(string(b), "\n")
-		for _, line := range lines {
-			if line == "{" || line == "}" {
-				continue
-			}
-			fmt.Printf("%s  %s\n", colorFunc(prefix), line)
+			fmt.Fprintf(p.w, "%s%s%s\n", colorFunc(prefix), indent, p.truncateScalar(fmt.Sprint(v)))
 		}
 	}
 }
 
-// areObjectsEqual compares two objects recursively
-func (p *DiffPrinter) areObjectsEqual(a, b map[string]interface{}) bool {
-	if len(a) != len(b) {
+func isScalar(val interface{}) bool {
+	switch val.(type) {
+	case map[string]interface{}, []interface{}:
 		return false
 	}
-
-	for k, v1 := range a {
-		v2, ok := b[k]
-		if !ok {
-			return false
-		}
-
-		switch val1 := v1.(type) {
-		case map[string]interface{}:
-			if val2, ok := v2.(map[string]interface{}); !ok || !p.areObjectsEqual(val1, val2) {
-				return false
-			}
-		case []interface{}:
-			if val2, ok := v2.([]interface{}); !ok || !p.areSlicesEqual(val1, val2) {
-				return false
-			}
-		default:
-			if v1 != v2 {
-				return false
-			}
-		}
-	}
-
-	return true
-}
-
-// areSlicesEqual compares two slices recursively
-func (p *DiffPrinter) areSlicesEqual(a, b []interface{}) bool {
-	if len(a) != len(b) {
-		return false
-	}
-
-	for i := range a {
-		switch v1 := a[i].(type) {
-		case map[string]interface{}:
-			if v2, ok := b[i].(map[string]interface{}); !ok || !p.areObjectsEqual(v1, v2) {
-				return false
-			}
-		case []interface{}:
-			if v2, ok := b[i].([]interface{}); !ok || !p.areSlicesEqual(v1, v2) {
-				return false
-			}
-		default:
-			if a[i] != b[i] {
-				return false
-			}
-		}
-	}
-
 	return true
 }
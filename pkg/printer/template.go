@@ -0,0 +1,92 @@
+package printer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/futuretea/kubectl-yadt/pkg/differ"
+	"sigs.k8s.io/yaml"
+)
+
+// TemplatePrinter renders each event with a Go template, in the same way
+// kubectl's go-template printer renders objects.
+type TemplatePrinter struct {
+	out  io.Writer
+	tmpl *template.Template
+}
+
+func NewTemplatePrinter(text string) (*TemplatePrinter, error) {
+	tmpl, err := template.New("output").Funcs(templateFuncs()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %w", text, err)
+	}
+	return &TemplatePrinter{out: os.Stdout, tmpl: tmpl}, nil
+}
+
+func NewTemplateFilePrinter(path string) (*TemplatePrinter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template %s: %w", path, err)
+	}
+	return NewTemplatePrinter(string(data))
+}
+
+// SetOutput sets where the events are written, stdout by default.
+func (p *TemplatePrinter) SetOutput(w io.Writer) {
+	p.out = w
+}
+
+func (p *TemplatePrinter) Print(event *differ.Event) error {
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, event); err != nil {
+		return fmt.Errorf("error executing template: %w", err)
+	}
+
+	// Keep one event per line for templates without a trailing newline
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	_, err := p.out.Write(buf.Bytes())
+	return err
+}
+
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"green":    color.New(color.FgGreen).SprintFunc(),
+		"red":      color.New(color.FgRed).SprintFunc(),
+		"yellow":   color.New(color.FgYellow).SprintFunc(),
+		"cyan":     color.New(color.FgCyan).SprintFunc(),
+		"json":     toJSON,
+		"yaml":     toYAML,
+		"truncate": truncate,
+	}
+}
+
+func toJSON(val interface{}) (string, error) {
+	b, err := json.Marshal(val)
+	return string(b), err
+}
+
+func toYAML(val interface{}) (string, error) {
+	b, err := yaml.Marshal(val)
+	return strings.TrimSuffix(string(b), "\n"), err
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis.
+func truncate(n int, s interface{}) string {
+	str := fmt.Sprint(s)
+	runes := []rune(str)
+	if n <= 0 || len(runes) <= n {
+		return str
+	}
+	if n == 1 {
+		return "…"
+	}
+	return string(runes[:n-1]) + "…"
+}
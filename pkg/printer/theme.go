@@ -0,0 +1,89 @@
+package printer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Theme holds the colors used to print a diff.
+type Theme struct {
+	Added    func(a ...interface{}) string
+	Removed  func(a ...interface{}) string
+	Modified func(a ...interface{}) string
+	Header   func(a ...interface{}) string
+	// InlineModified prints a modified value as a single "~" line instead
+	// of a "-"/"+" pair, for themes that can't rely on color to tell them apart.
+	InlineModified bool
+}
+
+var themes = map[string]Theme{
+	"default": {
+		Added:    color.New(color.FgGreen).SprintFunc(),
+		Removed:  color.New(color.FgRed).SprintFunc(),
+		Modified: color.New(color.FgYellow).SprintFunc(),
+		Header:   color.New(color.FgCyan).SprintFunc(),
+	},
+	// Blue and orange stay distinguishable with the common forms of color blindness
+	"colorblind": {
+		Added:    color.New(color.FgBlue, color.Bold).SprintFunc(),
+		Removed:  color.New(38, 5, 208).SprintFunc(),
+		Modified: color.New(color.FgMagenta).SprintFunc(),
+		Header:   color.New(color.Bold).SprintFunc(),
+	},
+	"monochrome": {
+		Added:          fmt.Sprint,
+		Removed:        fmt.Sprint,
+		Modified:       fmt.Sprint,
+		Header:         fmt.Sprint,
+		InlineModified: true,
+	},
+}
+
+// plainTheme returns theme without colors, for output that isn't a terminal.
+func plainTheme(theme Theme) Theme {
+	return Theme{
+		Added:          fmt.Sprint,
+		Removed:        fmt.Sprint,
+		Modified:       fmt.Sprint,
+		Header:         fmt.Sprint,
+		InlineModified: theme.InlineModified,
+	}
+}
+
+// LookupTheme returns the theme with the given name.
+func LookupTheme(name string) (Theme, error) {
+	theme, ok := themes[name]
+	if !ok {
+		return Theme{}, fmt.Errorf("unknown theme %q, must be one of: %s", name, strings.Join(ThemeNames(), ", "))
+	}
+	return theme, nil
+}
+
+// ThemeNames returns the names of all themes, sorted.
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetColor sets whether output is colorized. "auto" colorizes when writing
+// to a terminal and NO_COLOR is not set.
+func SetColor(mode string) error {
+	switch mode {
+	case "auto":
+		// color.NoColor already defaults to the terminal and NO_COLOR detection
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	default:
+		return fmt.Errorf("unknown color mode %q, must be one of: auto, always, never", mode)
+	}
+	return nil
+}
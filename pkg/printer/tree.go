@@ -0,0 +1,307 @@
+package printer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/futuretea/kubectl-yadt/pkg/differ"
+	"sigs.k8s.io/yaml"
+)
+
+// treeNode is a branch of the object that contains changes. Leaf nodes hold
+// the change for their path, other nodes only their changed children.
+type treeNode struct {
+	segment  interface{}
+	children []*treeNode
+	change   *differ.Change
+}
+
+// newTree nests changes by their path segments. Changes are already sorted
+// by path, so children keep the order they are added in.
+func newTree(changes []differ.Change) *treeNode {
+	root := &treeNode{}
+	for i := range changes {
+		node := root
+		for _, segment := range changes[i].Segments {
+			node = node.child(segment)
+		}
+		node.change = &changes[i]
+	}
+	return root
+}
+
+func (n *treeNode) child(segment interface{}) *treeNode {
+	if len(n.children) > 0 {
+		if last := n.children[len(n.children)-1]; last.segment == segment {
+			return last
+		}
+	}
+	child := &treeNode{segment: segment}
+	n.children = append(n.children, child)
+	return child
+}
+
+// countChanges returns the number of changes below n.
+func (n *treeNode) countChanges() int {
+	if n.change != nil {
+		return 1
+	}
+	count := 0
+	for _, child := range n.children {
+		count += child.countChanges()
+	}
+	return count
+}
+
+// identityFields are the fields that tell list elements apart, such as the
+// name of a container, in the order they are looked up.
+var identityFields = []string{"name", "key", "id", "type", "mountPath", "containerPort"}
+
+// printTree prints the changed children of node. old and new are the values
+// of the object at node, used to show unchanged fields as context.
+func (p *DiffPrinter) printTree(node *treeNode, indent string, old, new interface{}) {
+	changed := make(map[interface{}]*treeNode, len(node.children))
+	for _, child := range node.children {
+		changed[child.segment] = child
+	}
+
+	// Always show which element of a list this is
+	identity := ""
+	if _, ok := node.segment.(int); ok {
+		identity = identityField(new, old)
+		if identity != "" && changed[identity] == nil {
+			p.printContext(indent, identity, lookup(new, identity), lookup(old, identity))
+		}
+	}
+
+	segments := p.contextSegments(node, changed, old, new)
+	for _, segment := range segments {
+		child := changed[segment]
+		if child == nil {
+			if segment != identity {
+				p.printContext(indent, segment, lookup(new, segment), lookup(old, segment))
+			}
+			continue
+		}
+
+		label := "- "
+		if key, ok := child.segment.(string); ok {
+			label = key + ": "
+		}
+
+		if child.change == nil {
+			if p.w.full() {
+				p.skipped += child.countChanges()
+				continue
+			}
+			if index, ok := child.segment.(int); ok {
+				fmt.Fprintf(p.w, "  %s- # [%d]\n", indent, index)
+			} else {
+				fmt.Fprintf(p.w, "  %s%s\n", indent, strings.TrimSuffix(label, " "))
+			}
+			p.printTree(child, indent+"  ", lookup(old, child.segment), lookup(new, child.segment))
+			continue
+		}
+
+		if p.w.full() {
+			p.skipped++
+			continue
+		}
+
+		change := child.change
+		switch change.Type {
+		case differ.ChangeAdded:
+			p.printTreeValue(p.theme.Added("+"), indent, label, change.New)
+		case differ.ChangeRemoved:
+			p.printTreeValue(p.theme.Removed("-"), indent, label, change.Old)
+		default:
+			oldLines, newLines := yamlLines(change.Old), yamlLines(change.New)
+			if p.theme.InlineModified && isScalar(change.Old) && isScalar(change.New) && len(oldLines) == 1 && len(newLines) == 1 {
+				fmt.Fprintf(p.w, "%s %s%s%s → %s\n", p.theme.Modified("~"), indent, label,
+					p.truncateScalar(oldLines[0]), p.truncateScalar(newLines[0]))
+				continue
+			}
+			p.printTreeValue(p.theme.Removed("-"), indent, label, change.Old)
+			p.printTreeValue(p.theme.Added("+"), indent, label, change.New)
+		}
+	}
+}
+
+// contextSegments returns the changed children of node in order, along with
+// up to p.context unchanged siblings before and after each of them.
+func (p *DiffPrinter) contextSegments(node *treeNode, changed map[interface{}]*treeNode, old, new interface{}) []interface{} {
+	children := make([]interface{}, 0, len(node.children))
+	for _, child := range node.children {
+		children = append(children, child.segment)
+	}
+	// The top level fields are whole sections rather than siblings
+	if p.context <= 0 || node.segment == nil {
+		return children
+	}
+
+	siblings := siblingSegments(old, new)
+	found := 0
+	for _, segment := range siblings {
+		if changed[segment] != nil {
+			found++
+		}
+	}
+	if found != len(children) {
+		return children
+	}
+
+	show := make([]bool, len(siblings))
+	for i, segment := range siblings {
+		if changed[segment] == nil {
+			continue
+		}
+		for j := i - p.context; j <= i+p.context; j++ {
+			if j >= 0 && j < len(siblings) {
+				show[j] = true
+			}
+		}
+	}
+
+	var result []interface{}
+	for i, segment := range siblings {
+		if show[i] {
+			result = append(result, segment)
+		}
+	}
+	return result
+}
+
+// printContext prints an unchanged field, collapsing nested values.
+func (p *DiffPrinter) printContext(indent string, segment, val, fallback interface{}) {
+	if val == nil {
+		val = fallback
+	}
+
+	label := "-"
+	if key, ok := segment.(string); ok {
+		label = key + ":"
+	}
+
+	var value string
+	switch v := val.(type) {
+	case map[string]interface{}:
+		value = "{…}"
+		if len(v) == 0 {
+			value = "{}"
+		} else if field := identityField(v); field != "" {
+			value = fmt.Sprintf("{%s: %s, …}", field, yamlLines(v[field])[0])
+		}
+	case []interface{}:
+		value = "[…]"
+		if len(v) == 0 {
+			value = "[]"
+		}
+	default:
+		lines := yamlLines(val)
+		value = lines[0]
+		if len(lines) > 1 {
+			value += " …"
+		}
+	}
+	fmt.Fprintf(p.w, "  %s%s %s\n", indent, label, value)
+}
+
+// siblingSegments returns the keys or indexes of old and new merged, in
+// the order they are diffed.
+func siblingSegments(old, new interface{}) []interface{} {
+	var segments []interface{}
+	switch newVal := new.(type) {
+	case map[string]interface{}:
+		keys := make(map[string]bool, len(newVal))
+		for k := range newVal {
+			keys[k] = true
+		}
+		if oldVal, ok := old.(map[string]interface{}); ok {
+			for k := range oldVal {
+				keys[k] = true
+			}
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			segments = append(segments, k)
+		}
+	case []interface{}:
+		n := len(newVal)
+		if oldVal, ok := old.([]interface{}); ok && len(oldVal) > n {
+			n = len(oldVal)
+		}
+		for i := 0; i < n; i++ {
+			segments = append(segments, i)
+		}
+	default:
+		if old != nil {
+			return siblingSegments(nil, old)
+		}
+	}
+	return segments
+}
+
+func identityField(vals ...interface{}) string {
+	for _, val := range vals {
+		if m, ok := val.(map[string]interface{}); ok {
+			for _, field := range identityFields {
+				if v, ok := m[field]; ok && isScalar(v) {
+					return field
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func lookup(val interface{}, segment interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if key, ok := segment.(string); ok {
+			return v[key]
+		}
+	case []interface{}:
+		if index, ok := segment.(int); ok && index < len(v) {
+			return v[index]
+		}
+	}
+	return nil
+}
+
+// printTreeValue prints val as YAML under label, keeping scalars on the
+// label's line and starting list elements on the dash's line like YAML does.
+func (p *DiffPrinter) printTreeValue(marker, indent, label string, val interface{}) {
+	lines := yamlLines(val)
+	inline := isScalar(val) || len(lines) == 1
+	lines = p.truncateLines(lines)
+	switch {
+	case inline:
+		// Block scalars are already indented below their first line
+		fmt.Fprintf(p.w, "%s %s%s%s\n", marker, indent, label, lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(p.w, "%s %s%s\n", marker, indent, line)
+		}
+		return
+	case label == "- ":
+		fmt.Fprintf(p.w, "%s %s- %s\n", marker, indent, lines[0])
+		lines = lines[1:]
+	default:
+		fmt.Fprintf(p.w, "%s %s%s\n", marker, indent, strings.TrimSuffix(label, " "))
+	}
+	for _, line := range lines {
+		fmt.Fprintf(p.w, "%s %s  %s\n", marker, indent, line)
+	}
+}
+
+func yamlLines(val interface{}) []string {
+	b, err := yaml.Marshal(val)
+	if err != nil {
+		return []string{fmt.Sprint(val)}
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
@@ -0,0 +1,168 @@
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+type KeyType int
+
+const (
+	KeyRune KeyType = iota
+	KeyEnter
+	KeyEsc
+	KeyBackspace
+	KeyCtrlC
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyPgUp
+	KeyPgDn
+	KeyHome
+	KeyEnd
+)
+
+// Key is a key press read from the terminal. Rune is only set for KeyRune.
+type Key struct {
+	Type KeyType
+	Rune rune
+}
+
+// MakeRaw puts the terminal f into raw mode and returns a function that
+// restores its previous state.
+func MakeRaw(f *os.File) (func(), error) {
+	state, err := term.MakeRaw(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		_ = term.Restore(int(f.Fd()), state)
+	}, nil
+}
+
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Size returns the width and height of the terminal f, falling back to
+// 80x24 when it can't be determined.
+func Size(f *os.File) (int, int) {
+	width, height, err := term.GetSize(int(f.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// ReadKeys decodes the key presses read from r, which should be a terminal
+// in raw mode. The channel is closed when r returns an error.
+func ReadKeys(ctx context.Context, r io.Reader) <-chan Key {
+	keys := make(chan Key, 16)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 64)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, key := range parseKeys(buf[:n]) {
+				select {
+				case keys <- key:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return keys
+}
+
+var escapeKeys = map[string]KeyType{
+	"[A":  KeyUp,
+	"[B":  KeyDown,
+	"[C":  KeyRight,
+	"[D":  KeyLeft,
+	"[H":  KeyHome,
+	"[F":  KeyEnd,
+	"[1~": KeyHome,
+	"[4~": KeyEnd,
+	"[5~": KeyPgUp,
+	"[6~": KeyPgDn,
+	"OA":  KeyUp,
+	"OB":  KeyDown,
+	"OC":  KeyRight,
+	"OD":  KeyLeft,
+}
+
+func parseKeys(b []byte) []Key {
+	var keys []Key
+	for len(b) > 0 {
+		switch b[0] {
+		case 0x1b:
+			key, n, ok := parseEscape(b[1:])
+			if ok {
+				keys = append(keys, key)
+			}
+			b = b[1+n:]
+			continue
+		case 0x03:
+			keys = append(keys, Key{Type: KeyCtrlC})
+		case '\r', '\n':
+			keys = append(keys, Key{Type: KeyEnter})
+		case 0x7f, 0x08:
+			keys = append(keys, Key{Type: KeyBackspace})
+		default:
+			r, n := utf8.DecodeRune(b)
+			keys = append(keys, Key{Type: KeyRune, Rune: r})
+			b = b[n:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// parseEscape decodes the escape sequence following an ESC byte and returns
+// how many bytes it used. A lone ESC is the escape key itself, unknown
+// sequences are skipped.
+func parseEscape(b []byte) (Key, int, bool) {
+	for seq, keyType := range escapeKeys {
+		if strings.HasPrefix(string(b), seq) {
+			return Key{Type: keyType}, len(seq), true
+		}
+	}
+	if len(b) > 0 && b[0] == '[' {
+		for i := 1; i < len(b); i++ {
+			if b[i] >= 0x40 && b[i] <= 0x7e {
+				return Key{}, i + 1, false
+			}
+		}
+		return Key{}, len(b), false
+	}
+	return Key{Type: KeyEsc}, 0, true
+}
+
+// crlfWriter turns "\n" into "\r\n", since a terminal in raw mode no longer
+// returns the cursor to the start of the line on its own.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func NewCRLFWriter(w io.Writer) io.Writer {
+	return &crlfWriter{w: w}
+}
+
+func (c *crlfWriter) Write(b []byte) (int, error) {
+	if _, err := c.w.Write(bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
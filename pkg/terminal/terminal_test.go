@@ -0,0 +1,43 @@
+package terminal
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Key
+	}{
+		{"rune", "q", []Key{{Type: KeyRune, Rune: 'q'}}},
+		{"multibyte rune", "é", []Key{{Type: KeyRune, Rune: 'é'}}},
+		{"invalid utf8", "\xff", []Key{{Type: KeyRune, Rune: utf8.RuneError}}},
+		{"enter", "\r", []Key{{Type: KeyEnter}}},
+		{"newline", "\n", []Key{{Type: KeyEnter}}},
+		{"backspace", "\x7f", []Key{{Type: KeyBackspace}}},
+		{"ctrl-h", "\x08", []Key{{Type: KeyBackspace}}},
+		{"ctrl-c", "\x03", []Key{{Type: KeyCtrlC}}},
+		{"arrow", "\x1b[A", []Key{{Type: KeyUp}}},
+		{"application arrow", "\x1bOD", []Key{{Type: KeyLeft}}},
+		{"page down", "\x1b[6~", []Key{{Type: KeyPgDn}}},
+		{"home", "\x1b[1~", []Key{{Type: KeyHome}}},
+		{"lone escape", "\x1b", []Key{{Type: KeyEsc}}},
+		{"escape then rune", "\x1bx", []Key{{Type: KeyEsc}, {Type: KeyRune, Rune: 'x'}}},
+		{"unknown sequence is skipped", "\x1b[1;5Cq", []Key{{Type: KeyRune, Rune: 'q'}}},
+		{"truncated sequence is skipped", "\x1b[1", nil},
+		{"keys around a sequence", "a\x1b[5~b", []Key{{Type: KeyRune, Rune: 'a'}, {Type: KeyPgUp}, {Type: KeyRune, Rune: 'b'}}},
+		{"sequences back to back", "\x1b[B\x1b[B", []Key{{Type: KeyDown}, {Type: KeyDown}}},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseKeys([]byte(tt.input)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseKeys(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// reportInterval is how often throttling is reported.
+const reportInterval = 10 * time.Second
+
+// minWait is the shortest client-side wait that counts as throttling.
+const minWait = 50 * time.Millisecond
+
+// Stats are the throttled requests since the start.
+type Stats struct {
+	// Waited requests were delayed by the client-side rate limit
+	Waited int64
+	// Rejected requests were throttled by the server
+	Rejected int64
+}
+
+// Reporter reports the requests delayed by the client-side rate limit and
+// the requests throttled by the server, summed up every reportInterval.
+type Reporter struct {
+	lock       sync.Mutex
+	out        io.Writer
+	waited     int
+	waitedFor  time.Duration
+	rejected   int
+	retryAfter string
+	total      Stats
+}
+
+func New(out io.Writer) *Reporter {
+	return &Reporter{out: out}
+}
+
+// Configure sets the client-side rate limit of config, where a qps of 0 or
+// less disables it, and reports throttling of the requests made with it.
+func (r *Reporter) Configure(config *rest.Config, qps float32, burst int) {
+	config.QPS = qps
+	config.Burst = burst
+	config.RateLimiter = nil
+	if qps <= 0 {
+		config.QPS = -1
+	} else {
+		config.RateLimiter = &rateLimiter{
+			RateLimiter: flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+			reporter:    r,
+		}
+	}
+
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &roundTripper{rt: rt, reporter: r}
+	})
+}
+
+func (r *Reporter) wait(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.waited++
+	r.waitedFor += d
+	r.total.Waited++
+}
+
+func (r *Reporter) reject(retryAfter string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.rejected++
+	r.retryAfter = retryAfter
+	r.total.Rejected++
+}
+
+// Stats returns the throttled requests since the start, which are not reset
+// by the reports.
+func (r *Reporter) Stats() Stats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.total
+}
+
+// Run reports throttling every reportInterval until ctx is done.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+// report prints and resets the counters.
+func (r *Reporter) report() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.waited > 0 {
+		fmt.Fprintf(r.out, "Warning: %d requests waited %s for the client-side rate limit, see --qps and --burst\n",
+			r.waited, r.waitedFor.Round(time.Millisecond))
+	}
+	if r.rejected > 0 {
+		msg := fmt.Sprintf("Warning: the server throttled %d requests", r.rejected)
+		if r.retryAfter != "" {
+			msg += fmt.Sprintf(", retrying after %ss", r.retryAfter)
+		}
+		fmt.Fprintln(r.out, msg)
+	}
+	r.waited, r.waitedFor, r.rejected, r.retryAfter = 0, 0, 0, ""
+}
+
+// rateLimiter reports the waits of the rate limiter it wraps.
+type rateLimiter struct {
+	flowcontrol.RateLimiter
+	reporter *Reporter
+}
+
+func (l *rateLimiter) Accept() {
+	start := time.Now()
+	l.RateLimiter.Accept()
+	if d := time.Since(start); d >= minWait {
+		l.reporter.wait(d)
+	}
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	start := time.Now()
+	err := l.RateLimiter.Wait(ctx)
+	if d := time.Since(start); d >= minWait {
+		l.reporter.wait(d)
+	}
+	return err
+}
+
+// roundTripper reports the responses telling the client to back off.
+type roundTripper struct {
+	rt       http.RoundTripper
+	reporter *Reporter
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		t.reporter.reject(resp.Header.Get("Retry-After"))
+	}
+	return resp, err
+}
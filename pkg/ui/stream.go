@@ -0,0 +1,161 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/futuretea/kubectl-yadt/pkg/differ"
+	"github.com/futuretea/kubectl-yadt/pkg/terminal"
+	"github.com/futuretea/kubectl-yadt/pkg/watcher"
+	"github.com/sirupsen/logrus"
+)
+
+const streamHelp = "keys: p pause/resume  s toggle status  m toggle meta  / filter by name  c clear  ? help  q quit"
+
+// Stream adds keyboard controls to the plain streaming output of watch. It
+// wraps the printer of the differ to apply the name filter, so that filtered
+// objects still update the differ cache.
+type Stream struct {
+	printer differ.Printer
+	differ  *differ.Differ
+	out     io.Writer
+
+	paused    bool
+	filter    string
+	filtering bool
+	input     string
+}
+
+// NewStream returns a Stream printing events with printer. out is where
+// the printer writes, used for the messages shown after each key.
+func NewStream(printer differ.Printer, out io.Writer) *Stream {
+	return &Stream{
+		printer: printer,
+		out:     out,
+	}
+}
+
+func (s *Stream) Print(event *differ.Event) error {
+	if s.filter != "" && !strings.Contains(strings.ToLower(event.Name), strings.ToLower(s.filter)) {
+		return nil
+	}
+	return s.printer.Print(event)
+}
+
+// Run feeds the events received from the watcher to d and handles key
+// presses until the user quits or ctx is done.
+func (s *Stream) Run(ctx context.Context, d *differ.Differ, events <-chan watcher.Event) error {
+	s.differ = d
+
+	restore, err := terminal.MakeRaw(os.Stdin)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	s.message(streamHelp)
+	keys := terminal.ReadKeys(ctx, os.Stdin)
+	for {
+		// Leave the events in the watcher's queue while output is paused,
+		// so that its backpressure policy applies
+		received := events
+		if s.paused || s.filtering {
+			received = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-received:
+			if !ok {
+				return nil
+			}
+			s.diff(event)
+		case key, ok := <-keys:
+			if !ok || s.handleKey(key) {
+				return nil
+			}
+		}
+	}
+}
+
+func (s *Stream) diff(event watcher.Event) {
+	if err := s.differ.Print(event); err != nil {
+		logrus.WithError(err).Debug("Failed to print diff")
+	}
+}
+
+// handleKey applies a key press and reports whether the user quit.
+func (s *Stream) handleKey(key terminal.Key) bool {
+	if s.filtering {
+		switch key.Type {
+		case terminal.KeyEnter:
+			s.filtering = false
+			s.filter = s.input
+			fmt.Fprint(s.out, "\r\x1b[K")
+			if s.filter == "" {
+				s.message("filter cleared")
+			} else {
+				s.message("showing names containing " + s.filter)
+			}
+		case terminal.KeyEsc:
+			s.filtering = false
+			fmt.Fprint(s.out, "\r\x1b[K")
+		case terminal.KeyBackspace:
+			if runes := []rune(s.input); len(runes) > 0 {
+				s.input = string(runes[:len(runes)-1])
+			}
+			fmt.Fprintf(s.out, "\r\x1b[Kfilter: %s", s.input)
+		case terminal.KeyCtrlC:
+			return true
+		case terminal.KeyRune:
+			s.input += string(key.Rune)
+			fmt.Fprintf(s.out, "\r\x1b[Kfilter: %s", s.input)
+		}
+		return false
+	}
+
+	switch key.Type {
+	case terminal.KeyCtrlC:
+		return true
+	case terminal.KeyRune:
+		switch key.Rune {
+		case 'q':
+			return true
+		case 'p', ' ':
+			s.paused = !s.paused
+			if s.paused {
+				s.message("paused, press p to resume")
+			} else {
+				s.message("resumed")
+			}
+		case 's':
+			s.differ.SetIgnoreStatus(!s.differ.IgnoreStatus())
+			s.message(fmt.Sprintf("ignore status changes: %t", s.differ.IgnoreStatus()))
+		case 'm':
+			if s.differ.MetadataOnly() {
+				s.message("metadata changes are always shown with --metadata-only")
+				break
+			}
+			s.differ.SetIgnoreMeta(!s.differ.IgnoreMeta())
+			s.message(fmt.Sprintf("ignore metadata changes: %t", s.differ.IgnoreMeta()))
+		case 'c':
+			fmt.Fprint(s.out, "\x1b[H\x1b[2J")
+		case '/':
+			s.filtering = true
+			s.input = s.filter
+			fmt.Fprintf(s.out, "filter: %s", s.input)
+		case '?':
+			s.message(streamHelp)
+		}
+	}
+	return false
+}
+
+func (s *Stream) message(msg string) {
+	fmt.Fprintln(s.out, color.New(color.Faint).Sprintf("-- %s --", msg))
+}
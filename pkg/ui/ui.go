@@ -0,0 +1,519 @@
+package ui
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/futuretea/kubectl-yadt/pkg/differ"
+	"github.com/futuretea/kubectl-yadt/pkg/printer"
+	"github.com/futuretea/kubectl-yadt/pkg/terminal"
+	"github.com/futuretea/kubectl-yadt/pkg/throttle"
+	"github.com/futuretea/kubectl-yadt/pkg/watcher"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// maxRevisions is how many diffs are kept for each object.
+const maxRevisions = 100
+
+// maxObjects is how many objects are listed, past it the unchanged ones and
+// then the least recently changed ones are dropped.
+const maxObjects = 20000
+
+// View feeds the events received from the watcher to the differ while
+// handling user input.
+type View interface {
+	Run(ctx context.Context, d *differ.Differ, events <-chan watcher.Event) error
+}
+
+// object is the diff history of one watched object.
+type object struct {
+	key   string
+	title string
+	// revisions are the rendered diffs, rendering them once keeps the
+	// objects they were made from out of memory
+	revisions [][]string
+	// elem is the object in UI.order
+	elem *list.Element
+}
+
+// UI is a full screen interface listing the watched objects on the left and
+// the diff history of the selected object on the right.
+type UI struct {
+	printer *printer.DiffPrinter
+	differ  *differ.Differ
+
+	objects map[string]*object
+	// order holds the objects most recently changed first, followed by the
+	// listed objects that haven't changed
+	order *list.List
+	dirty bool
+
+	queueStats func() watcher.QueueStats
+	dropped    watcher.QueueStats
+
+	throttleStats func() throttle.Stats
+	throttled     throttle.Stats
+
+	selected  string
+	revision  int // index into the selected object's revisions, -1 for latest
+	scroll    int
+	paused    bool
+	filter    string
+	filtering bool
+}
+
+func New(printer *printer.DiffPrinter) *UI {
+	return &UI{
+		printer:  printer,
+		objects:  make(map[string]*object),
+		order:    list.New(),
+		revision: -1,
+		dirty:    true,
+	}
+}
+
+// SetQueueStats sets where the dropped and coalesced events shown in the
+// status line are read from.
+func (u *UI) SetQueueStats(stats func() watcher.QueueStats) {
+	u.queueStats = stats
+}
+
+// SetThrottleStats sets where the throttled requests shown in the status
+// line are read from.
+func (u *UI) SetThrottleStats(stats func() throttle.Stats) {
+	u.throttleStats = stats
+}
+
+// Print renders event into the history of its object. It is called by the
+// differ from Run.
+func (u *UI) Print(event *differ.Event) error {
+	obj := u.object(event.New)
+
+	var buf bytes.Buffer
+	u.printer.SetOutput(&buf)
+	if err := u.printer.Print(event); err != nil {
+		return err
+	}
+	lines := strings.Split(strings.ReplaceAll(buf.String(), "\t", "    "), "\n")
+
+	obj.revisions = append(obj.revisions, lines)
+	u.order.MoveToFront(obj.elem)
+	if len(obj.revisions) > maxRevisions {
+		obj.revisions = obj.revisions[len(obj.revisions)-maxRevisions:]
+		if obj.key == u.selected && u.revision > 0 {
+			u.revision--
+		}
+	}
+	u.evict()
+	u.dirty = true
+	return nil
+}
+
+// object returns the history of watched, adding an empty one after the
+// changed objects when it is new.
+func (u *UI) object(watched *unstructured.Unstructured) *object {
+	key := watcher.Key(watched)
+	if obj := u.objects[key]; obj != nil {
+		return obj
+	}
+
+	title := watched.GetName()
+	if namespace := watched.GetNamespace(); namespace != "" {
+		title = namespace + "/" + title
+	}
+	obj := &object{
+		key:   key,
+		title: strings.ToLower(watched.GetKind()) + " " + title,
+	}
+	obj.elem = u.order.PushBack(obj)
+	u.objects[key] = obj
+	return obj
+}
+
+// evict drops the objects past maxObjects, unchanged ones first and then
+// the least recently changed ones, other than the selected one.
+func (u *UI) evict() {
+	for e := u.order.Back(); e != nil && len(u.objects) > maxObjects; {
+		obj := e.Value.(*object)
+		e = e.Prev()
+		if obj.key == u.selected {
+			continue
+		}
+		u.order.Remove(obj.elem)
+		delete(u.objects, obj.key)
+	}
+}
+
+// Run feeds the events received from the watcher to d and draws the screen
+// until the user quits or ctx is done.
+func (u *UI) Run(ctx context.Context, d *differ.Differ, events <-chan watcher.Event) error {
+	u.differ = d
+
+	restore, err := terminal.MakeRaw(os.Stdin)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	// Switch to the alternate screen and hide the cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := terminal.ReadKeys(ctx, os.Stdin)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		// Leave the events in the watcher's queue while paused, so that
+		// its backpressure policy applies
+		received := events
+		if u.paused {
+			received = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-received:
+			if !ok {
+				return nil
+			}
+			u.diff(event)
+		case key, ok := <-keys:
+			if !ok || u.handleKey(key) {
+				return nil
+			}
+			u.dirty = true
+		case <-ticker.C:
+			if u.queueStats != nil {
+				if stats := u.queueStats(); stats != u.dropped {
+					u.dropped = stats
+					u.dirty = true
+				}
+			}
+			if u.throttleStats != nil {
+				if stats := u.throttleStats(); stats != u.throttled {
+					u.throttled = stats
+					u.dirty = true
+				}
+			}
+			if u.dirty {
+				u.draw()
+				u.dirty = false
+			}
+		}
+	}
+}
+
+func (u *UI) diff(event watcher.Event) {
+	// Listed objects are not diffed, but are listed to be found while they
+	// don't change
+	if event.Type == watcher.Listed {
+		u.object(event.Object)
+		u.evict()
+		u.dirty = true
+		return
+	}
+	if err := u.differ.Print(event); err != nil {
+		logrus.WithError(err).Debug("Failed to print diff")
+	}
+}
+
+// handleKey applies a key press and reports whether the user quit.
+func (u *UI) handleKey(key terminal.Key) bool {
+	if u.filtering {
+		switch key.Type {
+		case terminal.KeyEnter:
+			u.filtering = false
+		case terminal.KeyEsc:
+			u.filtering = false
+			u.filter = ""
+		case terminal.KeyBackspace:
+			if runes := []rune(u.filter); len(runes) > 0 {
+				u.filter = string(runes[:len(runes)-1])
+			}
+		case terminal.KeyCtrlC:
+			return true
+		case terminal.KeyRune:
+			u.filter += string(key.Rune)
+		}
+		return false
+	}
+
+	switch key.Type {
+	case terminal.KeyCtrlC:
+		return true
+	case terminal.KeyUp:
+		u.move(-1)
+	case terminal.KeyDown:
+		u.move(1)
+	case terminal.KeyLeft:
+		u.step(-1)
+	case terminal.KeyRight:
+		u.step(1)
+	case terminal.KeyHome:
+		u.revision = 0
+		u.scroll = 0
+	case terminal.KeyEnd:
+		u.revision = -1
+		u.scroll = 0
+	case terminal.KeyPgUp:
+		u.scroll -= 10
+		if u.scroll < 0 {
+			u.scroll = 0
+		}
+	case terminal.KeyPgDn:
+		u.scroll += 10
+	case terminal.KeyEsc:
+		u.filter = ""
+	case terminal.KeyRune:
+		switch key.Rune {
+		case 'q':
+			return true
+		case 'k':
+			u.move(-1)
+		case 'j':
+			u.move(1)
+		case 'h':
+			u.step(-1)
+		case 'l':
+			u.step(1)
+		case ' ', 'p':
+			u.paused = !u.paused
+		case '/':
+			u.filtering = true
+		case 's':
+			u.differ.SetIgnoreStatus(!u.differ.IgnoreStatus())
+		case 'm':
+			// Metadata is all there is to diff with --metadata-only
+			if !u.differ.MetadataOnly() {
+				u.differ.SetIgnoreMeta(!u.differ.IgnoreMeta())
+			}
+		}
+	}
+	return false
+}
+
+// move selects the object delta rows away from the selected one.
+func (u *UI) move(delta int) {
+	list := u.list()
+	if len(list) == 0 {
+		return
+	}
+
+	index := 0
+	for i, obj := range list {
+		if obj.key == u.selected {
+			index = i + delta
+			break
+		}
+	}
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(list) {
+		index = len(list) - 1
+	}
+	u.selected = list[index].key
+	u.revision = -1
+	u.scroll = 0
+}
+
+// step moves delta revisions back or forward in the selected object's history.
+func (u *UI) step(delta int) {
+	obj := u.objects[u.selected]
+	if obj == nil {
+		return
+	}
+
+	revision := u.revision
+	if revision < 0 {
+		revision = len(obj.revisions) - 1
+	}
+	revision += delta
+	if revision < 0 {
+		revision = 0
+	}
+	if revision >= len(obj.revisions)-1 {
+		revision = -1
+	}
+	u.revision = revision
+	u.scroll = 0
+}
+
+// list returns the objects matching the filter, most recently changed first.
+func (u *UI) list() []*object {
+	filter := strings.ToLower(u.filter)
+	var result []*object
+	for e := u.order.Front(); e != nil; e = e.Next() {
+		obj := e.Value.(*object)
+		if filter == "" || strings.Contains(strings.ToLower(obj.title), filter) {
+			result = append(result, obj)
+		}
+	}
+	return result
+}
+
+func (u *UI) draw() {
+	width, height := terminal.Size(os.Stdout)
+	listWidth := width / 3
+	if listWidth > 50 {
+		listWidth = 50
+	}
+	diffWidth := width - listWidth - 1
+	rows := height - 2
+
+	list := u.list()
+	if u.objects[u.selected] == nil || !contains(list, u.selected) {
+		u.selected = ""
+		if len(list) > 0 {
+			u.selected = list[0].key
+		}
+		u.revision = -1
+	}
+
+	// Left pane, scrolled to keep the selection visible
+	var left []string
+	offset := 0
+	for i, obj := range list {
+		if obj.key == u.selected && i >= rows {
+			offset = i - rows + 1
+		}
+	}
+	for i := offset; i < len(list) && len(left) < rows; i++ {
+		line := fmt.Sprintf(" %s (%d)", list[i].title, len(list[i].revisions))
+		line = clip(line, listWidth)
+		if list[i].key == u.selected {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		left = append(left, line)
+	}
+
+	// Right pane
+	title, right := u.renderSelected()
+	if u.scroll > len(right)-1 {
+		u.scroll = len(right) - 1
+	}
+	if u.scroll < 0 {
+		u.scroll = 0
+	}
+	right = right[u.scroll:]
+
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	screen.WriteString("\x1b[7m" + clip(fmt.Sprintf(" yadt  %d objects  %s", len(list), title), width) + "\x1b[0m\r\n")
+	for i := 0; i < rows; i++ {
+		l, r := strings.Repeat(" ", listWidth), strings.Repeat(" ", diffWidth)
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = clip(right[i], diffWidth)
+		}
+		screen.WriteString(l + "│" + r + "\r\n")
+	}
+	screen.WriteString("\x1b[7m" + clip(u.statusLine(), width) + "\x1b[0m")
+	fmt.Print(screen.String())
+}
+
+// renderSelected returns a description of the shown revision and its diff.
+func (u *UI) renderSelected() (string, []string) {
+	obj := u.objects[u.selected]
+	if obj == nil || len(obj.revisions) == 0 {
+		return "", []string{" waiting for changes…"}
+	}
+
+	revision := u.revision
+	if revision < 0 {
+		revision = len(obj.revisions) - 1
+	}
+	return fmt.Sprintf("│ %s  revision %d/%d", obj.title, revision+1, len(obj.revisions)), obj.revisions[revision]
+}
+
+func (u *UI) statusLine() string {
+	if u.filtering {
+		return " filter: " + u.filter + "█"
+	}
+
+	var state []string
+	if u.paused {
+		state = append(state, "PAUSED")
+	}
+	if u.filter != "" {
+		state = append(state, "filter: "+u.filter)
+	}
+	if u.dropped.Dropped > 0 {
+		state = append(state, fmt.Sprintf("%d dropped", u.dropped.Dropped))
+	}
+	if u.dropped.Coalesced > 0 {
+		state = append(state, fmt.Sprintf("%d coalesced", u.dropped.Coalesced))
+	}
+	if u.throttled.Waited > 0 {
+		state = append(state, fmt.Sprintf("%d rate limited", u.throttled.Waited))
+	}
+	if u.throttled.Rejected > 0 {
+		state = append(state, fmt.Sprintf("%d throttled by server", u.throttled.Rejected))
+	}
+	if u.differ.IgnoreStatus() {
+		state = append(state, "no-status")
+	}
+	if u.differ.IgnoreMeta() {
+		state = append(state, "no-meta")
+	}
+	keys := "↑↓ select  ←→ revision  pgup/pgdn scroll  / filter  p pause  s status  m meta  q quit"
+	if u.differ.MetadataOnly() {
+		keys = strings.Replace(keys, "  m meta", "", 1)
+	}
+	state = append(state, keys)
+	return " " + strings.Join(state, "  │  ")
+}
+
+func contains(list []*object, key string) bool {
+	for _, obj := range list {
+		if obj.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// clip cuts s to width visible characters and pads it with spaces, keeping
+// the color escape sequences intact.
+func clip(s string, width int) string {
+	var b strings.Builder
+	visible := 0
+	escaped := false
+	for i := 0; i < len(s) && visible < width; {
+		if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '[' {
+			j := i + 2
+			for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+				j++
+			}
+			if j < len(s) {
+				j++
+			}
+			b.WriteString(s[i:j])
+			escaped = true
+			i = j
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteRune(r)
+		i += size
+		visible++
+	}
+	if escaped {
+		b.WriteString("\x1b[0m")
+	}
+	if visible < width {
+		b.WriteString(strings.Repeat(" ", width-visible))
+	}
+	return b.String()
+}
@@ -0,0 +1,225 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// preflightWorkers is how many resources are checked at the same time.
+const preflightWorkers = 16
+
+// Access is what the preflight found the user may watch of a resource.
+type Access struct {
+	Resource string
+	Allowed  bool
+	// Namespaces is set when the resource is only watched in some of the
+	// requested namespaces
+	Namespaces []string
+}
+
+// SetDefaultNamespace sets the namespace to fall back to for namespaced
+// resources that can't be watched cluster-wide, when namespaces can't be
+// listed either.
+func (w *Watcher) SetDefaultNamespace(namespace string) {
+	w.defaultNamespace = namespace
+}
+
+// Access returns what the preflight found for each selected resource.
+func (w *Watcher) Access() []Access {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]Access(nil), w.access...)
+}
+
+// preflight checks with SelfSubjectAccessReviews that the user may list and
+// watch each resource in the requested namespaces, and returns the ones that
+// can be watched. A namespaced resource that can't be watched cluster-wide
+// falls back to the namespaces where SelfSubjectRulesReviews allow it.
+func (w *Watcher) preflight(ctx context.Context, resources []resource) []resource {
+	results := make([]*Access, len(resources))
+	sem := make(chan struct{}, preflightWorkers)
+	var wg sync.WaitGroup
+	for i, r := range resources {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, r resource) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = w.checkAccess(ctx, r)
+		}(i, r)
+	}
+	wg.Wait()
+
+	var allowed []resource
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for i, r := range resources {
+		w.access = append(w.access, *results[i])
+		if !results[i].Allowed {
+			continue
+		}
+		if results[i].Namespaces != nil {
+			w.scoped[r.gvr] = results[i].Namespaces
+		}
+		allowed = append(allowed, r)
+	}
+	return allowed
+}
+
+func (w *Watcher) checkAccess(ctx context.Context, r resource) *Access {
+	access := &Access{Resource: r.gvr.GroupResource().String()}
+
+	namespaces := w.namespacesFor(r)
+	if r.namespaced && w.selected != nil {
+		// The selected namespaces change while watching
+		namespaces = []string{""}
+	}
+	var allowed []string
+	for _, namespace := range namespaces {
+		if w.canWatch(ctx, r, namespace) {
+			allowed = append(allowed, namespace)
+		}
+	}
+
+	switch {
+	case len(allowed) == len(namespaces):
+	case r.namespaced && (len(w.namespaces) == 0 || w.selected != nil):
+		allowed = w.allowedNamespaces(ctx, r)
+		access.Namespaces = allowed
+	default:
+		access.Namespaces = allowed
+	}
+
+	access.Allowed = len(allowed) > 0
+	return access
+}
+
+// canWatch returns whether the user may list and watch r in namespace. When
+// access can't be reviewed, it assumes so and leaves it to the watch to fail.
+func (w *Watcher) canWatch(ctx context.Context, r resource, namespace string) bool {
+	for _, verb := range []string{"list", "watch"} {
+		review, err := w.auth.SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Group:     r.gvr.Group,
+					Resource:  r.gvr.Resource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			logrus.WithError(err).Debug("Failed to review access")
+			return true
+		}
+		if !review.Status.Allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// allowedNamespaces returns the namespaces whose rules allow listing and
+// watching r.
+func (w *Watcher) allowedNamespaces(ctx context.Context, r resource) []string {
+	var result []string
+	for _, namespace := range w.candidateNamespaces(ctx) {
+		rules := w.rules(ctx, namespace)
+		if allows(rules, "list", r) && allows(rules, "watch", r) {
+			result = append(result, namespace)
+		}
+	}
+	return result
+}
+
+// candidateNamespaces returns every namespace, or the default namespace when
+// they can't be listed.
+func (w *Watcher) candidateNamespaces(ctx context.Context) []string {
+	w.rulesLock.Lock()
+	defer w.rulesLock.Unlock()
+
+	if w.candidates == nil {
+		list, err := w.client.Resource(namespacesResource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			logrus.WithError(err).Debug("Failed to list namespaces")
+			w.candidates = []string{}
+			if w.defaultNamespace != "" {
+				w.candidates = []string{w.defaultNamespace}
+			}
+		} else {
+			w.candidates = []string{}
+			for _, item := range list.Items {
+				w.candidates = append(w.candidates, item.GetName())
+			}
+		}
+	}
+	return w.candidates
+}
+
+// rulesEntry is the review of the rules in a namespace, done is closed once
+// rules is set.
+type rulesEntry struct {
+	done  chan struct{}
+	rules []authorizationv1.ResourceRule
+}
+
+// rules returns the resource rules of the user in namespace. Each namespace
+// is reviewed once, by the first caller, while the others wait for it.
+// Failed reviews are not cached.
+func (w *Watcher) rules(ctx context.Context, namespace string) []authorizationv1.ResourceRule {
+	w.rulesLock.Lock()
+	if entry, ok := w.rulesCache[namespace]; ok {
+		w.rulesLock.Unlock()
+		select {
+		case <-entry.done:
+			return entry.rules
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	entry := &rulesEntry{done: make(chan struct{})}
+	w.rulesCache[namespace] = entry
+	w.rulesLock.Unlock()
+	defer close(entry.done)
+
+	review, err := w.auth.SelfSubjectRulesReviews().Create(ctx, &authorizationv1.SelfSubjectRulesReview{
+		Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		logrus.WithError(err).WithField("namespace", namespace).Debug("Failed to review rules")
+		w.rulesLock.Lock()
+		delete(w.rulesCache, namespace)
+		w.rulesLock.Unlock()
+		return nil
+	}
+
+	entry.rules = review.Status.ResourceRules
+	return entry.rules
+}
+
+// allows returns whether rules allow verb on every object of r. Rules
+// limited to some resource names don't, since the watch is not.
+func allows(rules []authorizationv1.ResourceRule, verb string, r resource) bool {
+	for _, rule := range rules {
+		if len(rule.ResourceNames) > 0 {
+			continue
+		}
+		if has(rule.Verbs, verb) && has(rule.APIGroups, r.gvr.Group) && has(rule.Resources, r.gvr.Resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func has(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+	return false
+}
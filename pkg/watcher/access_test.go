@@ -0,0 +1,43 @@
+package watcher
+
+import (
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestAllows(t *testing.T) {
+	deployments := resource{gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, namespaced: true}
+
+	tests := []struct {
+		name  string
+		rules []authorizationv1.ResourceRule
+		want  bool
+	}{
+		{"no rules", nil, false},
+		{"matching rule", []authorizationv1.ResourceRule{
+			{Verbs: []string{"get", "list"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+		}, true},
+		{"wildcards", []authorizationv1.ResourceRule{
+			{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}},
+		}, true},
+		{"other verb", []authorizationv1.ResourceRule{
+			{Verbs: []string{"get"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+		}, false},
+		{"other group", []authorizationv1.ResourceRule{
+			{Verbs: []string{"list"}, APIGroups: []string{""}, Resources: []string{"deployments"}},
+		}, false},
+		{"limited to resource names", []authorizationv1.ResourceRule{
+			{Verbs: []string{"list"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}, ResourceNames: []string{"api"}},
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allows(tt.rules, "list", deployments); got != tt.want {
+				t.Errorf("allows() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package watcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// resyncDelay batches the API changes seen in a short time, such as a CRD
+// being created and then becoming established, into one resync.
+const resyncDelay = 2 * time.Second
+
+var apiResources = []schema.GroupVersionResource{
+	{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"},
+	{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"},
+}
+
+// followsAPIs returns whether the selected resources can change with the
+// APIs of the cluster, which is when watching everything or wildcards.
+func (w *Watcher) followsAPIs() bool {
+	if len(w.matchers) == 0 {
+		return true
+	}
+	for _, m := range w.matchers {
+		if m.wildcard() {
+			return true
+		}
+	}
+	return false
+}
+
+// watchAPIs follows the metadata of the CRDs and APIServices the user may
+// watch, and resyncs the watched resources with discovery when they change.
+func (w *Watcher) watchAPIs(ctx context.Context, send func(Event)) {
+	changed := func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		if w.resyncTimer == nil {
+			w.resyncTimer = time.AfterFunc(resyncDelay, func() {
+				w.lock.Lock()
+				w.resyncTimer = nil
+				w.lock.Unlock()
+				w.resync(ctx, send)
+			})
+		}
+	}
+
+	for _, gvr := range apiResources {
+		if !w.canWatch(ctx, resource{gvr: gvr}, "") {
+			logrus.WithField("resource", gvr.String()).Debug("Not following API changes, forbidden to list and watch")
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		client := w.metadata.Resource(gvr)
+		listed := newInitialObjects()
+		lw := listed.wrap(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return client.List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return client.Watch(ctx, options)
+			},
+		})
+
+		i := cache.NewSharedIndexInformer(lw, &metav1.PartialObjectMetadata{}, 0, cache.Indexers{})
+		_ = i.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			logrus.WithError(err).WithField("resource", gvr.String()).Debug("Failed to follow API changes")
+			if apierrors.IsForbidden(err) {
+				cancel()
+			}
+		})
+		i.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				// APIs that existed at start are already discovered
+				if m, ok := obj.(*metav1.PartialObjectMetadata); ok && !listed.listed(m.UID) {
+					changed()
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				// Spec and status changes, such as a CRD becoming established
+				// or an APIService available, only show in the resourceVersion
+				old, ok1 := oldObj.(*metav1.PartialObjectMetadata)
+				cur, ok2 := newObj.(*metav1.PartialObjectMetadata)
+				if ok1 && ok2 && old.ResourceVersion != cur.ResourceVersion {
+					changed()
+				}
+			},
+			DeleteFunc: func(interface{}) {
+				changed()
+			},
+		})
+		go i.Run(ctx.Done())
+	}
+}
+
+// resync refreshes discovery, then starts watching the resources that
+// appeared and stops watching the ones that disappeared.
+func (w *Watcher) resync(ctx context.Context, send func(Event)) {
+	w.resyncLock.Lock()
+	defer w.resyncLock.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	w.Refresh()
+	resources, err := w.resources()
+	if err != nil {
+		logrus.WithError(err).Debug("Failed to resync resources")
+		return
+	}
+
+	w.lock.Lock()
+	running := make(map[schema.GroupVersionResource]bool, len(w.running))
+	for gvr := range w.running {
+		running[gvr] = true
+	}
+	w.lock.Unlock()
+
+	var added []resource
+	for _, r := range resources {
+		if running[r.gvr] {
+			delete(running, r.gvr)
+			continue
+		}
+		added = append(added, r)
+	}
+
+	added, err = w.supportFieldSelector(ctx, w.preflight(ctx, added))
+	if err != nil {
+		logrus.Warnf("Not watching resource: %v", err)
+	}
+	for _, r := range added {
+		w.startResource(ctx, r, false, send)
+	}
+
+	for gvr := range running {
+		w.stopResource(gvr)
+	}
+}
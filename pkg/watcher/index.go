@@ -0,0 +1,114 @@
+package watcher
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+)
+
+// apiResource is an API resource known to discovery.
+type apiResource struct {
+	resource
+	verbs      sets.String
+	singular   string
+	shortNames []string
+}
+
+func (a *apiResource) listWatchable() bool {
+	return a.verbs.HasAll("list", "watch")
+}
+
+// index maps the API resources of every served version by GVR, so that
+// lookups don't walk the discovery documents.
+type index struct {
+	byGVR     map[schema.GroupVersionResource]*apiResource
+	preferred []*apiResource
+}
+
+func newIndex(cdi discovery.CachedDiscoveryInterface) (*index, error) {
+	_, lists, err := cdi.ServerGroupsAndResources()
+	if err != nil {
+		if len(lists) == 0 {
+			return nil, err
+		}
+		// Some API groups failed, index the ones that were discovered
+		logrus.WithError(err).Debug("Failed to discover some API groups")
+	}
+
+	i := &index{
+		byGVR: make(map[schema.GroupVersionResource]*apiResource),
+	}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+
+		for _, r := range list.APIResources {
+			// Skip subresources
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+
+			a := &apiResource{
+				resource: resource{
+					gvr:        gv.WithResource(r.Name),
+					gvk:        gv.WithKind(r.Kind),
+					namespaced: r.Namespaced,
+				},
+				verbs:      sets.NewString(r.Verbs...),
+				singular:   r.SingularName,
+				shortNames: r.ShortNames,
+			}
+			i.byGVR[a.gvr] = a
+		}
+	}
+
+	preferred, err := cdi.ServerPreferredResources()
+	if err != nil && len(preferred) == 0 {
+		return nil, err
+	}
+	for _, list := range preferred {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if a := i.byGVR[gv.WithResource(r.Name)]; a != nil {
+				i.preferred = append(i.preferred, a)
+			}
+		}
+	}
+
+	return i, nil
+}
+
+// discovery returns the index, building it on first use.
+func (w *Watcher) discovery() (*index, error) {
+	w.indexLock.Lock()
+	defer w.indexLock.Unlock()
+
+	if w.index == nil {
+		i, err := newIndex(w.cdi)
+		if err != nil {
+			return nil, err
+		}
+		w.index = i
+	}
+	return w.index, nil
+}
+
+// Refresh drops the cached discovery information and REST mappings, so that
+// the next lookup sees the API resources currently served.
+func (w *Watcher) Refresh() {
+	w.indexLock.Lock()
+	defer w.indexLock.Unlock()
+
+	w.cdi.Invalidate()
+	meta.MaybeResetRESTMapper(w.mapper)
+	w.index = nil
+}
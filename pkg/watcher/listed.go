@@ -0,0 +1,71 @@
+package watcher
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// initialObjects records the objects of an informer's initial list, which
+// are the ones returned by the lists made before its first watch. This
+// tells listed objects from added ones without comparing the server's
+// creation timestamps with the local clock.
+type initialObjects struct {
+	lock    sync.Mutex
+	uids    map[types.UID]bool
+	watched bool
+}
+
+func newInitialObjects() *initialObjects {
+	return &initialObjects{uids: make(map[types.UID]bool)}
+}
+
+// wrap returns lw recording the objects of the initial list.
+func (o *initialObjects) wrap(lw cache.ListerWatcher) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := lw.List(options)
+			if err == nil {
+				o.record(list)
+			}
+			return list, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			o.lock.Lock()
+			o.watched = true
+			o.lock.Unlock()
+			return lw.Watch(options)
+		},
+	}
+}
+
+func (o *initialObjects) record(list runtime.Object) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.watched {
+		return
+	}
+	_ = meta.EachListItem(list, func(obj runtime.Object) error {
+		if m, err := meta.Accessor(obj); err == nil {
+			o.uids[m.GetUID()] = true
+		}
+		return nil
+	})
+}
+
+// listed returns whether the object with uid was in the initial list, and
+// forgets it so that it is added if it is later recreated with the same uid.
+func (o *initialObjects) listed(uid types.UID) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if !o.uids[uid] {
+		return false
+	}
+	delete(o.uids, uid)
+	return true
+}
@@ -0,0 +1,61 @@
+package watcher
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SetMetadataOnly watches only the metadata of objects, which the server
+// sends as PartialObjectMetadata, so that spec and status are never held.
+func (w *Watcher) SetMetadataOnly(metadataOnly bool) {
+	w.metadataOnly = metadataOnly
+}
+
+// metadataListWatch lists and watches the metadata of r in namespace.
+func (w *Watcher) metadataListWatch(ctx context.Context, r resource, namespace, fieldSelector string) cache.ListerWatcher {
+	var client metadata.ResourceInterface = w.metadata.Resource(r.gvr)
+	if r.namespaced && namespace != "" {
+		client = w.metadata.Resource(r.gvr).Namespace(namespace)
+	}
+
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = w.labelSelector
+			options.FieldSelector = fieldSelector
+			return client.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = w.labelSelector
+			options.FieldSelector = fieldSelector
+			return client.Watch(ctx, options)
+		},
+	}
+}
+
+// metadataTransform turns the PartialObjectMetadata of r into unstructured
+// objects of its kind before they are stored, dropping the managed fields.
+func metadataTransform(r resource) cache.TransformFunc {
+	return func(obj interface{}) (interface{}, error) {
+		partial, ok := obj.(*metav1.PartialObjectMetadata)
+		if !ok {
+			return obj, nil
+		}
+
+		partial = partial.DeepCopy()
+		partial.ManagedFields = nil
+		data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(partial)
+		if err != nil {
+			return nil, err
+		}
+
+		u := &unstructured.Unstructured{Object: data}
+		u.SetGroupVersionKind(r.gvk)
+		return u, nil
+	}
+}
@@ -0,0 +1,217 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+var namespacesResource = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// namespaceSet is the set of namespaces matching the namespace selector.
+type namespaceSet struct {
+	lock  sync.RWMutex
+	names map[string]bool
+}
+
+func (s *namespaceSet) add(name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.names[name] {
+		return false
+	}
+	s.names[name] = true
+	return true
+}
+
+func (s *namespaceSet) remove(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.names, name)
+}
+
+func (s *namespaceSet) list() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]string, 0, len(s.names))
+	for name := range s.names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// SetNamespaces limits the watch to the given namespaces, none for all
+// namespaces.
+func (w *Watcher) SetNamespaces(namespaces []string) {
+	w.namespaces = namespaces
+}
+
+// SetExcludeNamespaces skips the objects in the given namespaces.
+func (w *Watcher) SetExcludeNamespaces(namespaces []string) {
+	w.excludeNamespaces = namespaces
+}
+
+// SetNamespaceSelector limits the watch to the namespaces whose labels match
+// selector. The namespaces are followed while watching, so the objects of a
+// namespace created or relabeled to match are reported as added.
+func (w *Watcher) SetNamespaceSelector(selector string) error {
+	if _, err := labels.Parse(selector); err != nil {
+		return err
+	}
+	w.namespaceSelector = selector
+	return nil
+}
+
+// namespacesFor returns the namespaces to start informers in for r, where
+// the empty namespace means all of them. The preflight may have narrowed
+// them down to the namespaces the user has access to.
+func (w *Watcher) namespacesFor(r resource) []string {
+	if w.selected != nil && r.namespaced {
+		var result []string
+		for _, namespace := range w.selected.list() {
+			if w.namespaceWatched(r, namespace) {
+				result = append(result, namespace)
+			}
+		}
+		return result
+	}
+	if namespaces, ok := w.scoped[r.gvr]; ok {
+		return namespaces
+	}
+	if !r.namespaced || len(w.namespaces) == 0 {
+		return []string{""}
+	}
+	return w.namespaces
+}
+
+// namespaceWatched returns whether the selected namespace is watched for r,
+// given the requested, excluded and allowed namespaces.
+func (w *Watcher) namespaceWatched(r resource, namespace string) bool {
+	if len(w.namespaces) > 0 && !contains(w.namespaces, namespace) {
+		return false
+	}
+	if contains(w.excludeNamespaces, namespace) {
+		return false
+	}
+	if scoped, ok := w.scoped[r.gvr]; ok {
+		return contains(scoped, namespace)
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldSelectorFor returns the field selector for r, with the excluded
+// namespaces added so that the server filters them out.
+func (w *Watcher) fieldSelectorFor(r resource) string {
+	var selectors []string
+	if w.fieldSelector != "" {
+		selectors = append(selectors, w.fieldSelector)
+	}
+	if r.namespaced {
+		for _, namespace := range w.excludeNamespaces {
+			selectors = append(selectors, "metadata.namespace!="+namespace)
+		}
+	}
+	return strings.Join(selectors, ",")
+}
+
+// watchNamespaces follows the namespaces matching the namespace selector and
+// waits until they are listed. When a namespace starts matching later on,
+// informers are started in it for the namespaced resources, reporting the
+// objects already in it as added, and they are stopped when it no longer
+// matches.
+func (w *Watcher) watchNamespaces(ctx context.Context) error {
+	if !w.canWatch(ctx, resource{gvr: namespacesResource}, "") {
+		return fmt.Errorf("--namespace-selector requires permission to list and watch namespaces")
+	}
+	w.selected = &namespaceSet{names: make(map[string]bool)}
+
+	client := w.client.Resource(namespacesResource)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = w.namespaceSelector
+			return client.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = w.namespaceSelector
+			return client.Watch(ctx, options)
+		},
+	}
+
+	// The server sends a delete when a namespace is relabeled to no longer
+	// match, and an add when it starts matching
+	informer := cache.NewSharedIndexInformer(lw, &unstructured.Unstructured{}, 0, cache.Indexers{})
+	// Fail instead of waiting forever when the namespaces can't be listed
+	listed := initialList(ctx, informer, func(err error) {
+		logrus.WithError(err).Debug("Failed to watch namespaces")
+	})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if u, ok := obj.(*unstructured.Unstructured); ok && w.selected.add(u.GetName()) {
+				logrus.WithField("namespace", u.GetName()).Debug("Namespace selected")
+				w.startSelected(u.GetName())
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				logrus.WithField("namespace", u.GetName()).Debug("Namespace no longer selected")
+				w.selected.remove(u.GetName())
+				w.stopSelected(u.GetName())
+			}
+		},
+	})
+
+	go informer.Run(ctx.Done())
+	if err := <-listed; err != nil {
+		return fmt.Errorf("failed to list namespaces matching %q: %w", w.namespaceSelector, err)
+	}
+	return nil
+}
+
+// startSelected starts the informers of the running namespaced resources in
+// the newly selected namespace.
+func (w *Watcher) startSelected(namespace string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, run := range w.running {
+		if run.resource.namespaced && w.namespaceWatched(run.resource, namespace) {
+			w.startNamespace(run, namespace, false)
+		}
+	}
+}
+
+// stopSelected stops the informers in the namespace that is no longer
+// selected.
+func (w *Watcher) stopSelected(namespace string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, run := range w.running {
+		if cancel, ok := run.namespaces[namespace]; ok {
+			cancel()
+			delete(run.namespaces, namespace)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestNamespacesFor(t *testing.T) {
+	pods := resource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, namespaced: true}
+	nodes := resource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "nodes"}}
+
+	tests := []struct {
+		name       string
+		r          resource
+		namespaces []string
+		excluded   []string
+		selected   []string
+		scoped     []string
+		want       []string
+	}{
+		{"all namespaces", pods, nil, nil, nil, nil, []string{""}},
+		{"requested namespaces", pods, []string{"a", "b"}, nil, nil, nil, []string{"a", "b"}},
+		{"cluster scoped", nodes, []string{"a"}, nil, []string{"a"}, nil, []string{""}},
+		{"allowed namespaces", pods, nil, nil, nil, []string{"b"}, []string{"b"}},
+		{"selected namespaces", pods, nil, nil, []string{"b", "a"}, nil, []string{"a", "b"}},
+		{"selected without excluded", pods, nil, []string{"a"}, []string{"a", "b"}, nil, []string{"b"}},
+		{"selected and requested", pods, []string{"b", "c"}, nil, []string{"a", "b"}, nil, []string{"b"}},
+		{"selected and allowed", pods, nil, nil, []string{"a", "b"}, []string{"a"}, []string{"a"}},
+		{"nothing selected", pods, nil, nil, []string{}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Watcher{
+				namespaces:        tt.namespaces,
+				excludeNamespaces: tt.excluded,
+				scoped:            make(map[schema.GroupVersionResource][]string),
+			}
+			if tt.selected != nil {
+				w.selected = &namespaceSet{names: make(map[string]bool)}
+				for _, name := range tt.selected {
+					w.selected.add(name)
+				}
+			}
+			if tt.scoped != nil {
+				w.scoped[tt.r.gvr] = tt.scoped
+			}
+			if got := w.namespacesFor(tt.r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("namespacesFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package watcher
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Backpressure is what happens to events when the consumer falls behind.
+type Backpressure string
+
+const (
+	// Block makes the informers wait until the buffer has room
+	Block Backpressure = "block"
+	// DropOldest drops the oldest buffered event to make room
+	DropOldest Backpressure = "drop-oldest"
+	// Coalesce merges the buffered events of an object into one
+	Coalesce Backpressure = "coalesce"
+)
+
+// Backpressures are the supported policies.
+var Backpressures = []Backpressure{Block, DropOldest, Coalesce}
+
+// queueSize is how many events are buffered for block and drop-oldest.
+const queueSize = 100
+
+// QueueStats are the counters of the event queue.
+type QueueStats struct {
+	// Dropped events were discarded because the buffer was full
+	Dropped int64
+	// Coalesced events were merged into a buffered event of the same object
+	Coalesced int64
+}
+
+// SetBackpressure sets what happens to events when the consumer falls
+// behind.
+func (w *Watcher) SetBackpressure(policy string) error {
+	for _, p := range Backpressures {
+		if string(p) == policy {
+			w.queue.policy = p
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown backpressure policy %q, must be one of %v", policy, Backpressures)
+}
+
+// QueueStats returns the counters of the event queue.
+func (w *Watcher) QueueStats() QueueStats {
+	w.queue.lock.Lock()
+	defer w.queue.lock.Unlock()
+	return w.queue.stats
+}
+
+// queue buffers events between the informers and the consumer. Only the
+// block policy makes the informers wait; coalesce is bounded by the number
+// of watched objects instead of queueSize.
+type queue struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	policy Backpressure
+	events *list.List
+	keys   map[string]*list.Element
+	closed bool
+	stats  QueueStats
+}
+
+func newQueue() *queue {
+	q := &queue{
+		policy: Block,
+		events: list.New(),
+		keys:   make(map[string]*list.Element),
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+func (q *queue) push(event Event) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	switch q.policy {
+	case Block:
+		for q.events.Len() >= queueSize && !q.closed {
+			q.cond.Wait()
+		}
+	case DropOldest:
+		if q.events.Len() >= queueSize {
+			q.events.Remove(q.events.Front())
+			q.stats.Dropped++
+		}
+	case Coalesce:
+		key := Key(event.Object)
+		if e, ok := q.keys[key]; ok {
+			e.Value = coalesce(e.Value.(Event), event)
+			q.stats.Coalesced++
+			return
+		}
+		q.keys[key] = q.events.PushBack(event)
+		q.cond.Broadcast()
+		return
+	}
+	if q.closed {
+		return
+	}
+	q.events.PushBack(event)
+	q.cond.Broadcast()
+}
+
+// pop waits for the next event, and returns false once the queue is closed.
+func (q *queue) pop() (Event, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for q.events.Len() == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return Event{}, false
+	}
+	event := q.events.Remove(q.events.Front()).(Event)
+	if q.policy == Coalesce {
+		delete(q.keys, Key(event.Object))
+	}
+	q.cond.Broadcast()
+	return event, true
+}
+
+func (q *queue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// run moves the events to result until ctx is done, then closes result.
+func (q *queue) run(ctx context.Context, result chan<- Event) {
+	go func() {
+		<-ctx.Done()
+		q.close()
+	}()
+	defer close(result)
+
+	for {
+		event, ok := q.pop()
+		if !ok {
+			return
+		}
+		select {
+		case result <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// coalesce merges next into the buffered event of the same object, so that
+// it diffs from the version before the first one to the latest one.
+func coalesce(first, next Event) Event {
+	merged := Event{Type: next.Type, Object: next.Object, Old: first.Old}
+	switch {
+	case next.Type == Deleted:
+		merged.Old = nil
+	case first.Type == Listed && next.Type == Updated:
+		// The listed version is the one seen before
+		merged.Old = first.Object
+	case first.Type == Added || first.Type == Listed:
+		merged.Type = first.Type
+		merged.Old = nil
+	}
+	return merged
+}
@@ -0,0 +1,201 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func object(name, resourceVersion string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	u.SetAPIVersion("v1")
+	u.SetKind("Pod")
+	u.SetNamespace("default")
+	u.SetName(name)
+	u.SetResourceVersion(resourceVersion)
+	return u
+}
+
+func TestCoalesce(t *testing.T) {
+	v1, v2, v3 := object("a", "1"), object("a", "2"), object("a", "3")
+
+	tests := []struct {
+		name        string
+		first, next Event
+		want        Event
+	}{
+		{"updates keep the first old version",
+			Event{Type: Updated, Object: v2, Old: v1},
+			Event{Type: Updated, Object: v3, Old: v2},
+			Event{Type: Updated, Object: v3, Old: v1}},
+		{"update of a listed object diffs from the listed version",
+			Event{Type: Listed, Object: v1},
+			Event{Type: Updated, Object: v2, Old: v1},
+			Event{Type: Updated, Object: v2, Old: v1}},
+		{"update of an added object stays added",
+			Event{Type: Added, Object: v1},
+			Event{Type: Updated, Object: v2, Old: v1},
+			Event{Type: Added, Object: v2}},
+		{"listed again stays listed",
+			Event{Type: Listed, Object: v1},
+			Event{Type: Listed, Object: v2},
+			Event{Type: Listed, Object: v2}},
+		{"deletion after an update",
+			Event{Type: Updated, Object: v2, Old: v1},
+			Event{Type: Deleted, Object: v2},
+			Event{Type: Deleted, Object: v2}},
+		{"deletion after an add",
+			Event{Type: Added, Object: v1},
+			Event{Type: Deleted, Object: v1},
+			Event{Type: Deleted, Object: v1}},
+		{"recreation after a deletion is an add",
+			Event{Type: Deleted, Object: v1},
+			Event{Type: Added, Object: v2},
+			Event{Type: Added, Object: v2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := coalesce(tt.first, tt.next)
+			if got.Type != tt.want.Type || got.Object != tt.want.Object || got.Old != tt.want.Old {
+				t.Errorf("coalesce() = %s %v old %v, want %s %v old %v", got.Type, version(got.Object), version(got.Old),
+					tt.want.Type, version(tt.want.Object), version(tt.want.Old))
+			}
+		})
+	}
+}
+
+func version(u *unstructured.Unstructured) string {
+	if u == nil {
+		return "<nil>"
+	}
+	return u.GetResourceVersion()
+}
+
+func TestQueuePolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Backpressure
+		pushes []Event
+		want   []string // names of the popped objects
+		stats  QueueStats
+	}{
+		{
+			name:   "drop-oldest keeps the newest events",
+			policy: DropOldest,
+			pushes: events(queueSize + 2),
+			want:   names(2, queueSize+2),
+			stats:  QueueStats{Dropped: 2},
+		},
+		{
+			name:   "coalesce merges the events of an object",
+			policy: Coalesce,
+			pushes: []Event{
+				{Type: Added, Object: object("a", "1")},
+				{Type: Added, Object: object("b", "1")},
+				{Type: Updated, Object: object("a", "2")},
+				{Type: Updated, Object: object("a", "3")},
+			},
+			want:  []string{"a", "b"},
+			stats: QueueStats{Coalesced: 2},
+		},
+		{
+			name:   "coalesce is not bounded by the buffer size",
+			policy: Coalesce,
+			pushes: events(queueSize + 2),
+			want:   names(0, queueSize+2),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue()
+			q.policy = tt.policy
+			for _, event := range tt.pushes {
+				q.push(event)
+			}
+			q.close()
+			if q.stats != tt.stats {
+				t.Errorf("stats = %+v, want %+v", q.stats, tt.stats)
+			}
+
+			var got []string
+			for e := q.events.Front(); e != nil; e = e.Next() {
+				got = append(got, e.Value.(Event).Object.GetName())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d is %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQueueCoalescesOnlyQueuedEvents(t *testing.T) {
+	q := newQueue()
+	q.policy = Coalesce
+	q.push(Event{Type: Added, Object: object("a", "1")})
+	if event, _ := q.pop(); event.Type != Added {
+		t.Fatalf("popped %s, want added", event.Type)
+	}
+
+	// The add was received, so the update is kept as is
+	q.push(Event{Type: Updated, Object: object("a", "2"), Old: object("a", "1")})
+	if event, _ := q.pop(); event.Type != Updated {
+		t.Errorf("popped %s, want updated", event.Type)
+	}
+	if q.stats.Coalesced != 0 {
+		t.Errorf("coalesced %d events, want 0", q.stats.Coalesced)
+	}
+}
+
+func TestQueueBlockReleasedOnClose(t *testing.T) {
+	q := newQueue()
+	for _, event := range events(queueSize) {
+		q.push(event)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.push(Event{Type: Added, Object: object("blocked", "1")})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("push didn't block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("push still blocked after the queue was closed")
+	}
+	if q.events.Len() != queueSize {
+		t.Errorf("queue has %d events, want %d", q.events.Len(), queueSize)
+	}
+}
+
+func events(n int) []Event {
+	var result []Event
+	for _, name := range names(0, n) {
+		result = append(result, Event{Type: Added, Object: object(name, "1")})
+	}
+	return result
+}
+
+func names(from, to int) []string {
+	var result []string
+	for i := from; i < to; i++ {
+		result = append(result, "pod-"+string(rune('a'+i/26))+string(rune('a'+i%26)))
+	}
+	return result
+}
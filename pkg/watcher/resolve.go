@@ -0,0 +1,192 @@
+package watcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// resolve resolves the resource names of the matchers the way kubectl
+// does, expanding categories such as all and short names such as deploy,
+// and keeping an explicit version such as deployments.v1.apps.
+func (w *Watcher) resolve() error {
+	for i, m := range w.matchers {
+		if m.wildcard() {
+			continue
+		}
+
+		gvrs, err := w.resolveName(m.resource)
+		if err != nil {
+			// The resource may have been added since discovery was cached
+			w.Refresh()
+			if gvrs, err = w.resolveName(m.resource); err != nil {
+				return err
+			}
+		}
+
+		w.matchers[i].resources = make(map[schema.GroupVersionResource]bool)
+		for _, gvr := range gvrs {
+			if err := w.checkVerbs(m.resource, gvr); err != nil {
+				return err
+			}
+			w.matchers[i].resources[gvr] = true
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) resolveName(name string) ([]schema.GroupVersionResource, error) {
+	if groupResources, ok := w.categories.Expand(name); ok {
+		var result []schema.GroupVersionResource
+		// The expansion has a resource once for each version it is served in
+		seen := make(map[schema.GroupResource]bool)
+		for _, groupResource := range groupResources {
+			if seen[groupResource] {
+				continue
+			}
+			seen[groupResource] = true
+			mapping, err := w.mappingFor(groupResource.String())
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, mapping.Resource)
+		}
+		return result, nil
+	}
+
+	mapping, err := w.mappingFor(name)
+	if err != nil {
+		return nil, err
+	}
+	return []schema.GroupVersionResource{mapping.Resource}, nil
+}
+
+// mappingFor finds the mapping of a resource or kind argument, trying the
+// same forms in the same order as kubectl.
+func (w *Watcher) mappingFor(arg string) (*meta.RESTMapping, error) {
+	fullySpecifiedGVR, groupResource := schema.ParseResourceArg(arg)
+	gvk := schema.GroupVersionKind{}
+	if fullySpecifiedGVR != nil {
+		gvk, _ = w.mapper.KindFor(*fullySpecifiedGVR)
+	}
+	if gvk.Empty() {
+		gvk, _ = w.mapper.KindFor(groupResource.WithVersion(""))
+	}
+	if !gvk.Empty() {
+		return w.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+
+	fullySpecifiedGVK, groupKind := schema.ParseKindArg(arg)
+	if fullySpecifiedGVK == nil {
+		gvk := groupKind.WithVersion("")
+		fullySpecifiedGVK = &gvk
+	}
+	if !fullySpecifiedGVK.Empty() {
+		if mapping, err := w.mapper.RESTMapping(fullySpecifiedGVK.GroupKind(), fullySpecifiedGVK.Version); err == nil {
+			return mapping, nil
+		}
+	}
+
+	mapping, err := w.mapper.RESTMapping(groupKind, gvk.Version)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, w.unknownResource(groupResource.Resource)
+		}
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// checkVerbs returns an error when gvr, resolved from name, can't be both
+// listed and watched.
+func (w *Watcher) checkVerbs(name string, gvr schema.GroupVersionResource) error {
+	index, err := w.discovery()
+	if err != nil {
+		return err
+	}
+
+	a := index.byGVR[gvr]
+	if a == nil {
+		return w.unknownResource(name)
+	}
+
+	var missing []string
+	for _, verb := range []string{"list", "watch"} {
+		if !a.verbs.Has(verb) {
+			missing = append(missing, verb)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("resource type %q (%s) can't be watched, it doesn't support the %s verb",
+			name, gvr.GroupResource(), strings.Join(missing, " and "))
+	}
+	return nil
+}
+
+// unknownResource returns the error for a resource type that doesn't exist,
+// suggesting the closest resource names known to the server.
+func (w *Watcher) unknownResource(name string) error {
+	err := fmt.Errorf("the server doesn't have a resource type %q", name)
+
+	index, indexErr := w.discovery()
+	if indexErr != nil {
+		return err
+	}
+
+	best := -1
+	suggestions := sets.NewString()
+	for _, a := range index.preferred {
+		candidates := append([]string{a.gvr.Resource, a.singular}, a.shortNames...)
+		for _, candidate := range candidates {
+			d := distance(strings.ToLower(name), candidate)
+			if candidate == "" || d > len(name)/3+1 {
+				continue
+			}
+			if best < 0 || d < best {
+				best = d
+				suggestions = sets.NewString()
+			}
+			if d == best {
+				suggestions.Insert(a.gvr.Resource)
+			}
+		}
+	}
+
+	if suggestions.Len() == 0 {
+		return err
+	}
+	return fmt.Errorf("%w, did you mean %s?", err, strings.Join(quote(suggestions.List()), " or "))
+}
+
+func quote(names []string) []string {
+	result := make([]string, len(names))
+	for i, name := range names {
+		result[i] = strconv.Quote(name)
+	}
+	return result
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,182 @@
+package watcher
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/restmapper"
+)
+
+// fakeDiscovery serves fixed API groups, listing the preferred version of
+// each group first.
+type fakeDiscovery struct {
+	discovery.DiscoveryInterface
+	groups    []*metav1.APIGroup
+	resources []*metav1.APIResourceList
+}
+
+func (d *fakeDiscovery) ServerGroupsAndResources() ([]*metav1.APIGroup, []*metav1.APIResourceList, error) {
+	return d.groups, d.resources, nil
+}
+
+func (d *fakeDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	preferred := make(map[string]bool)
+	for _, g := range d.groups {
+		preferred[g.PreferredVersion.GroupVersion] = true
+	}
+	var result []*metav1.APIResourceList
+	for _, list := range d.resources {
+		if preferred[list.GroupVersion] {
+			result = append(result, list)
+		}
+	}
+	return result, nil
+}
+
+func (d *fakeDiscovery) Fresh() bool {
+	return true
+}
+
+func (d *fakeDiscovery) Invalidate() {}
+
+func group(name string, versions ...string) *metav1.APIGroup {
+	g := &metav1.APIGroup{Name: name}
+	for _, version := range versions {
+		gv := schema.GroupVersion{Group: name, Version: version}.String()
+		g.Versions = append(g.Versions, metav1.GroupVersionForDiscovery{GroupVersion: gv, Version: version})
+	}
+	g.PreferredVersion = g.Versions[0]
+	return g
+}
+
+var watchVerbs = metav1.Verbs{"get", "list", "watch"}
+
+// newTestWatcher returns a watcher resolving resources against a cluster
+// serving pods, services, bindings, deployments and two versions of
+// horizontalpodautoscalers, with autoscaling/v1 preferred.
+func newTestWatcher() *Watcher {
+	cdi := &fakeDiscovery{
+		groups: []*metav1.APIGroup{
+			group("", "v1"),
+			group("apps", "v1"),
+			group("autoscaling", "v1", "v2"),
+		},
+		resources: []*metav1.APIResourceList{
+			{GroupVersion: "v1", APIResources: []metav1.APIResource{
+				{Name: "pods", SingularName: "pod", Namespaced: true, Kind: "Pod", Verbs: watchVerbs, ShortNames: []string{"po"}, Categories: []string{"all"}},
+				{Name: "services", SingularName: "service", Namespaced: true, Kind: "Service", Verbs: watchVerbs, ShortNames: []string{"svc"}, Categories: []string{"all"}},
+				{Name: "bindings", SingularName: "binding", Namespaced: true, Kind: "Binding", Verbs: metav1.Verbs{"create"}},
+			}},
+			{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+				{Name: "deployments", SingularName: "deployment", Namespaced: true, Kind: "Deployment", Verbs: watchVerbs, ShortNames: []string{"deploy"}, Categories: []string{"all"}},
+			}},
+			{GroupVersion: "autoscaling/v1", APIResources: []metav1.APIResource{
+				{Name: "horizontalpodautoscalers", SingularName: "horizontalpodautoscaler", Namespaced: true, Kind: "HorizontalPodAutoscaler", Verbs: watchVerbs, ShortNames: []string{"hpa"}, Categories: []string{"all"}},
+			}},
+			{GroupVersion: "autoscaling/v2", APIResources: []metav1.APIResource{
+				{Name: "horizontalpodautoscalers", SingularName: "horizontalpodautoscaler", Namespaced: true, Kind: "HorizontalPodAutoscaler", Verbs: watchVerbs, ShortNames: []string{"hpa"}, Categories: []string{"all"}},
+			}},
+		},
+	}
+	return &Watcher{
+		mapper:     restmapper.NewShortcutExpander(restmapper.NewDeferredDiscoveryRESTMapper(cdi), cdi),
+		categories: restmapper.NewDiscoveryCategoryExpander(cdi),
+		cdi:        cdi,
+	}
+}
+
+func TestResolveName(t *testing.T) {
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	services := schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	hpaV1 := schema.GroupVersionResource{Group: "autoscaling", Version: "v1", Resource: "horizontalpodautoscalers"}
+	hpaV2 := schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}
+
+	tests := []struct {
+		name string
+		want []schema.GroupVersionResource
+	}{
+		{"pods", []schema.GroupVersionResource{pods}},
+		{"pod", []schema.GroupVersionResource{pods}},
+		{"po", []schema.GroupVersionResource{pods}},
+		{"Pod", []schema.GroupVersionResource{pods}},
+		{"deploy", []schema.GroupVersionResource{deployments}},
+		{"deployments.apps", []schema.GroupVersionResource{deployments}},
+		{"deployments.v1.apps", []schema.GroupVersionResource{deployments}},
+		{"hpa", []schema.GroupVersionResource{hpaV1}},
+		{"hpa.v2.autoscaling", []schema.GroupVersionResource{hpaV2}},
+		{"horizontalpodautoscalers.v2.autoscaling", []schema.GroupVersionResource{hpaV2}},
+		{"all", []schema.GroupVersionResource{pods, services, deployments, hpaV1}},
+	}
+
+	w := newTestWatcher()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := w.resolveName(tt.name)
+			if err != nil {
+				t.Fatalf("resolveName(%q) failed: %v", tt.name, err)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].String() < got[j].String() })
+			want := append([]schema.GroupVersionResource(nil), tt.want...)
+			sort.Slice(want, func(i, j int) bool { return want[i].String() < want[j].String() })
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("resolveName(%q) = %v, want %v", tt.name, got, want)
+			}
+		})
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"deploymnts", `the server doesn't have a resource type "deploymnts", did you mean "deployments"?`},
+		{"servce", `the server doesn't have a resource type "servce", did you mean "services"?`},
+		{"hpas", `the server doesn't have a resource type "hpas", did you mean "horizontalpodautoscalers"?`},
+		{"widgets", `the server doesn't have a resource type "widgets"`},
+		{"bindings", `resource type "bindings" (bindings) can't be watched, it doesn't support the list and watch verb`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher()
+			if err := w.MatchName(tt.name); err != nil {
+				t.Fatal(err)
+			}
+			err := w.resolve()
+			if err == nil {
+				t.Fatalf("resolve(%q) succeeded", tt.name)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("resolve(%q) = %q, want %q", tt.name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"pods", "pods", 0},
+		{"", "pods", 4},
+		{"pods", "", 4},
+		{"pod", "pods", 1},
+		{"deploymnts", "deployments", 1},
+		{"svc", "services", 5},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -2,131 +2,667 @@ package watcher
 
 import (
 	"context"
+	"fmt"
+	"path"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/rancher/lasso/pkg/dynamic"
-	"github.com/rancher/wrangler/pkg/clients"
 	"github.com/rancher/wrangler/pkg/kv"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	authorizationv1 "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
 )
 
+type EventType string
+
+const (
+	// Listed objects already existed when the watch started
+	Listed  EventType = "listed"
+	Added   EventType = "added"
+	Updated EventType = "updated"
+	Deleted EventType = "deleted"
+)
+
+// Event is a change to a watched object. Old is the previous version held
+// by the informer, only set for Updated.
+type Event struct {
+	Type   EventType
+	Object *unstructured.Unstructured
+	Old    *unstructured.Unstructured
+}
+
+// Key identifies obj among the watched objects by its API version, kind,
+// namespace and name.
+func Key(obj *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s/%s/%s/%s", obj.GetAPIVersion(), obj.GetKind(), obj.GetNamespace(), obj.GetName())
+}
+
+// DefaultExcludes are the noisy resources skipped unless they are watched
+// by name.
+var DefaultExcludes = []string{
+	"events",
+	"leases.coordination.k8s.io",
+	"endpointslices.discovery.k8s.io",
+}
+
+// matcher selects a resource by name and optionally its objects by name.
+type matcher struct {
+	resource string
+	pattern  string // glob on the object name, empty for all objects
+
+	// resources resolved from resource by resolve, unused for wildcards
+	resources map[schema.GroupVersionResource]bool
+}
+
+func (m matcher) wildcard() bool {
+	return strings.HasPrefix(m.resource, "*")
+}
+
 type Watcher struct {
-	mapper   meta.RESTMapper
-	cdi      discovery.CachedDiscoveryInterface
-	dynamic  *dynamic.Controller
-	matchers []dynamic.GVKMatcher
+	mapper          meta.RESTMapper
+	categories      restmapper.CategoryExpander
+	cdi             discovery.CachedDiscoveryInterface
+	client          dynamic.Interface
+	matchers        []matcher
+	excludes        []string
+	defaultExcludes bool
+	nameRegex       *regexp.Regexp
+	labelSelector   string
+	fieldSelector   string
+
+	namespaces        []string
+	excludeNamespaces []string
+	namespaceSelector string
+	selected          *namespaceSet
+
+	lock        sync.Mutex
+	running     map[schema.GroupVersionResource]*running
+	resyncTimer *time.Timer
+	resyncLock  sync.Mutex
+
+	indexLock sync.Mutex
+	index     *index
+
+	// starting holds a slot for each informer doing its initial list
+	starting chan struct{}
+
+	metadata     metadata.Interface
+	metadataOnly bool
+
+	// queue buffers the events until they are received
+	queue *queue
+
+	auth             authorizationv1.AuthorizationV1Interface
+	defaultNamespace string
+	access           []Access
+	scoped           map[schema.GroupVersionResource][]string
+	rulesLock        sync.Mutex
+	rulesCache       map[string]*rulesEntry
+	candidates       []string
+}
+
+// running is a watched resource and the cancel functions of its informers
+// by namespace, where the empty namespace means all of them.
+type running struct {
+	resource   resource
+	ctx        context.Context
+	cancel     context.CancelFunc
+	send       func(Event)
+	namespaces map[string]context.CancelFunc
 }
 
-func New(clients *clients.Clients) (*Watcher, error) {
-	mapper, err := clients.ToRESTMapper()
+// resource is an API resource that can be listed and watched.
+type resource struct {
+	gvr        schema.GroupVersionResource
+	gvk        schema.GroupVersionKind
+	namespaced bool
+}
+
+func New(config *rest.Config) (*Watcher, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	cdi := memory.NewMemCacheClient(discoveryClient)
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cdi)
+
+	client, err := dynamic.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	cdi, err := clients.ToDiscoveryClient()
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := authorizationv1.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Watcher{
-		mapper:  mapper,
-		cdi:     cdi,
-		dynamic: clients.Dynamic,
+		mapper:          restmapper.NewShortcutExpander(mapper, cdi),
+		categories:      restmapper.NewDiscoveryCategoryExpander(cdi),
+		cdi:             cdi,
+		client:          client,
+		defaultExcludes: true,
+		running:         make(map[schema.GroupVersionResource]*running),
+		metadata:        metadataClient,
+		auth:            auth,
+		scoped:          make(map[schema.GroupVersionResource][]string),
+		rulesCache:      make(map[string]*rulesEntry),
+		queue:           newQueue(),
 	}, nil
 }
 
-func (w *Watcher) isListWatchable(gvk schema.GroupVersionKind) bool {
-	mapping, err := w.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
-	if err != nil {
-		return false
+// SetLabelSelector sets the label selector sent with every list and watch
+// request, so that objects that don't match are never received.
+func (w *Watcher) SetLabelSelector(selector string) error {
+	if _, err := labels.Parse(selector); err != nil {
+		return err
 	}
+	w.labelSelector = selector
+	return nil
+}
 
-	_, resources, err := w.cdi.ServerGroupsAndResources()
-	if err != nil {
+// SetFieldSelector sets the field selector sent with every list and watch
+// request. Which fields are supported depends on the resource.
+func (w *Watcher) SetFieldSelector(selector string) error {
+	if _, err := fields.ParseSelector(selector); err != nil {
+		return err
+	}
+	w.fieldSelector = selector
+	return nil
+}
+
+// SetExcludes skips the resources matching any of patterns, given as a
+// resource in any group, resource.group, or *.group for a whole group.
+func (w *Watcher) SetExcludes(patterns []string) {
+	w.excludes = patterns
+}
+
+// SetDefaultExcludes sets whether DefaultExcludes are skipped.
+func (w *Watcher) SetDefaultExcludes(enabled bool) {
+	w.defaultExcludes = enabled
+}
+
+func (w *Watcher) shouldWatch(r resource) bool {
+	for _, pattern := range w.excludes {
+		if isExcluded(pattern, r) {
+			return false
+		}
+	}
+
+	matched := len(w.matchers) == 0
+	explicit := false
+	for _, m := range w.matchers {
+		if m.matches(r) {
+			matched = true
+			explicit = explicit || !m.wildcard()
+		}
+	}
+	if !matched {
 		return false
 	}
 
-	for _, res := range resources {
-		if res.GroupVersion == gvk.GroupVersion().String() {
-			for _, apiResource := range res.APIResources {
-				if apiResource.Name == mapping.Resource.Resource {
-					list := false
-					watch := false
-					for _, verb := range apiResource.Verbs {
-						if verb == "list" {
-							list = true
-						} else if verb == "watch" {
-							watch = true
-						}
-					}
-					return list && watch
-				}
+	if w.defaultExcludes && !explicit {
+		for _, pattern := range DefaultExcludes {
+			if isExcluded(pattern, r) {
+				return false
 			}
 		}
 	}
 
-	return false
+	return true
 }
 
-func (w *Watcher) shouldWatch(gvk schema.GroupVersionKind) bool {
-	if !w.isListWatchable(gvk) {
+// isExcluded is like isName, except that a pattern without a group matches
+// the resource in any group.
+func isExcluded(pattern string, r resource) bool {
+	if strings.Contains(pattern, ".") {
+		return isName(pattern, r)
+	}
+	return isName(pattern+"."+r.gvk.Group, r)
+}
+
+// nameFilter returns whether an object of r with the given name should be
+// watched, or nil when all its objects are.
+func (w *Watcher) nameFilter(r resource) func(name string) bool {
+	var patterns []string
+	all := len(w.matchers) == 0
+	for _, m := range w.matchers {
+		if !m.matches(r) {
+			continue
+		}
+		if m.pattern == "" {
+			all = true
+		}
+		patterns = append(patterns, m.pattern)
+	}
+
+	if all && w.nameRegex == nil {
+		return nil
+	}
+
+	return func(name string) bool {
+		if w.nameRegex != nil && !w.nameRegex.MatchString(name) {
+			return false
+		}
+		if all {
+			return true
+		}
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
 		return false
 	}
+}
 
-	if len(w.matchers) == 0 {
-		return true
+// resources returns the preferred version of every API resource that can
+// be listed and watched and is selected by the matchers.
+func (w *Watcher) resources() ([]resource, error) {
+	index, err := w.discovery()
+	if err != nil {
+		return nil, err
 	}
 
-	for _, matcher := range w.matchers {
-		if matcher(gvk) {
+	var result []resource
+	seen := make(map[schema.GroupVersionResource]bool)
+	for _, a := range index.preferred {
+		if a.listWatchable() && w.shouldWatch(a.resource) {
+			result = append(result, a.resource)
+			seen[a.gvr] = true
+		}
+	}
+
+	// Resources given with a version that isn't the preferred one
+	for _, m := range w.matchers {
+		for gvr := range m.resources {
+			if a := index.byGVR[gvr]; a != nil && !seen[gvr] && a.listWatchable() && w.shouldWatch(a.resource) {
+				result = append(result, a.resource)
+				seen[gvr] = true
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func anyMatches(m matcher, resources []resource) bool {
+	for _, r := range resources {
+		if m.matches(r) {
 			return true
 		}
 	}
+	return false
+}
+
+func (w *Watcher) Start(ctx context.Context) (chan Event, error) {
+	if err := w.resolve(); err != nil {
+		return nil, err
+	}
+
+	resources, err := w.resources()
+	if err != nil {
+		return nil, err
+	}
+
+	// Wildcards are followed as APIs are installed, so the first CRD of a
+	// group can appear later
+	for _, m := range w.matchers {
+		if m.wildcard() && !anyMatches(m, resources) {
+			logrus.Warnf("%q doesn't match any resource that can be watched yet", m.resource)
+		}
+	}
 
+	// The namespaces are needed to know where to check access
+	if w.namespaceSelector != "" {
+		if err := w.watchNamespaces(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(resources) > 0 {
+		resources = w.preflight(ctx, resources)
+		if len(resources) == 0 {
+			return nil, fmt.Errorf("not allowed to list and watch any of the selected resources")
+		}
+	}
+
+	result := make(chan Event)
+	go w.queue.run(ctx, result)
+	send := w.queue.push
+
+	resources, err = w.supportFieldSelector(ctx, resources)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range resources {
+		w.startResource(ctx, r, true, send)
+	}
+
+	if w.followsAPIs() {
+		w.watchAPIs(ctx, send)
+	}
+	return result, nil
+}
+
+// startResource starts the informers of r, one per watched namespace. Only
+// the resources watched from the start report their objects as Listed, the
+// objects of resources that appear later are all new to the watch.
+func (w *Watcher) startResource(ctx context.Context, r resource, initial bool, send func(Event)) {
+	ctx, cancel := context.WithCancel(ctx)
+	run := &running{
+		resource:   r,
+		ctx:        ctx,
+		cancel:     cancel,
+		send:       send,
+		namespaces: make(map[string]context.CancelFunc),
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.running[r.gvr] = run
+	for _, namespace := range w.namespacesFor(r) {
+		w.startNamespace(run, namespace, initial)
+	}
+}
+
+// startNamespace starts the informer of run in namespace, unless it is
+// already running. It must be called with w.lock held.
+func (w *Watcher) startNamespace(run *running, namespace string, initial bool) {
+	if _, ok := run.namespaces[namespace]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(run.ctx)
+	run.namespaces[namespace] = cancel
+
+	logrus.WithFields(logrus.Fields{
+		"resource":  run.resource.gvr.String(),
+		"namespace": namespace,
+	}).Debug("Watching resource")
+	w.watch(ctx, run.resource, namespace, initial, run.send)
+}
+
+// stopResource stops the informers of the resource gvr.
+func (w *Watcher) stopResource(gvr schema.GroupVersionResource) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	logrus.WithField("resource", gvr.String()).Debug("Stopped watching resource")
+	if run := w.running[gvr]; run != nil {
+		run.cancel()
+	}
+	delete(w.running, gvr)
+}
+
+// supportFieldSelector returns the resources that support the field
+// selector. The resources matched by a wildcard that don't are skipped with
+// a warning, while it is an error for the resources named explicitly. The
+// other resources are still returned with the error.
+func (w *Watcher) supportFieldSelector(ctx context.Context, resources []resource) ([]resource, error) {
+	if w.fieldSelector == "" {
+		return resources, nil
+	}
+
+	var result []resource
+	var explicitErr error
+	for _, r := range resources {
+		err := w.checkFieldSelector(ctx, r)
+		switch {
+		case err == nil:
+			result = append(result, r)
+		case w.explicit(r):
+			if explicitErr == nil {
+				explicitErr = err
+			}
+		default:
+			logrus.Warnf("Not watching %s: %v", r.gvr.GroupResource(), err)
+		}
+	}
+	return result, explicitErr
+}
+
+// explicit returns whether r is selected by name rather than by a wildcard.
+func (w *Watcher) explicit(r resource) bool {
+	for _, m := range w.matchers {
+		if !m.wildcard() && m.matches(r) {
+			return true
+		}
+	}
 	return false
 }
 
-func (w *Watcher) Start(ctx context.Context) (chan runtime.Object, error) {
-	var chanLock sync.Mutex
+// checkFieldSelector lists r with the field selector, since the server
+// only reports unsupported fields when it gets a request. Other errors are
+// left to the watch to report.
+func (w *Watcher) checkFieldSelector(ctx context.Context, r resource) error {
+	namespace := ""
+	if namespaces := w.namespacesFor(r); len(namespaces) > 0 {
+		namespace = namespaces[0]
+	}
+	_, err := w.resourceClient(r, namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: w.fieldSelector,
+		Limit:         1,
+	})
+	if apierrors.IsBadRequest(err) {
+		return fmt.Errorf("field selector %q is not supported by %s: %w", w.fieldSelector, r.gvr.GroupResource(), err)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("resource", r.gvr.String()).Debug("Failed to check field selector")
+	}
+	return nil
+}
+
+func (w *Watcher) resourceClient(r resource, namespace string) dynamic.ResourceInterface {
+	if r.namespaced && namespace != "" {
+		return w.client.Resource(r.gvr).Namespace(namespace)
+	}
+	return w.client.Resource(r.gvr)
+}
+
+// watch starts an informer for r in namespace that sends the events of the
+// objects passing the name filter to send. When initial is
+// set, the objects of the initial list are reported as Listed.
+func (w *Watcher) watch(ctx context.Context, r resource, namespace string, initial bool, send func(Event)) {
+	client := w.resourceClient(r, namespace)
+	fieldSelector := w.fieldSelectorFor(r)
+	filter := w.nameFilter(r)
+	emit := func(eventType EventType, obj, old *unstructured.Unstructured) {
+		if filter == nil || filter(obj.GetName()) {
+			send(Event{Type: eventType, Object: obj, Old: old})
+		}
+	}
+
+	var lw cache.ListerWatcher = &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = w.labelSelector
+			options.FieldSelector = fieldSelector
+			return client.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = w.labelSelector
+			options.FieldSelector = fieldSelector
+			return client.Watch(ctx, options)
+		},
+	}
+	var objType runtime.Object = &unstructured.Unstructured{}
+	if w.metadataOnly {
+		lw, objType = w.metadataListWatch(ctx, r, namespace, fieldSelector), &metav1.PartialObjectMetadata{}
+	}
+
+	listedObjects := newInitialObjects()
+	if initial {
+		lw = listedObjects.wrap(lw)
+	}
+	i := cache.NewSharedIndexInformer(lw, objType, 0, cache.Indexers{})
+	if w.metadataOnly {
+		_ = i.SetTransform(metadataTransform(r))
+	}
+	log := logrus.WithFields(logrus.Fields{
+		"resource":  r.gvr.String(),
+		"namespace": namespace,
+	})
+	listed := initialList(ctx, i, func(err error) {
+		log.WithError(err).Debug("Failed to watch resource")
+	})
+	i.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				eventType := Added
+				if listedObjects.listed(u.GetUID()) {
+					eventType = Listed
+				}
+				emit(eventType, u, nil)
+			}
+		},
+		UpdateFunc: func(oldObj, obj interface{}) {
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				old, _ := oldObj.(*unstructured.Unstructured)
+				emit(Updated, u, old)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				emit(Deleted, u, nil)
+			}
+		},
+	})
+
+	go w.run(ctx, i, listed, log)
+}
+
+// SetMaxConcurrentStarts limits how many informers do their initial list at
+// the same time, 0 for no limit.
+func (w *Watcher) SetMaxConcurrentStarts(n int) {
+	w.starting = nil
+	if n > 0 {
+		w.starting = make(chan struct{}, n)
+	}
+}
+
+// run runs the informer i once a start slot is free, and frees the slot
+// when its initial list is done, so that an informer retrying a failed list
+// doesn't hold it.
+func (w *Watcher) run(ctx context.Context, i cache.SharedIndexInformer, listed <-chan error, log *logrus.Entry) {
+	if w.starting != nil {
+		select {
+		case w.starting <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+	}
 
-	result := make(chan runtime.Object, 100)
 	go func() {
-		<-ctx.Done()
-		chanLock.Lock()
-		close(result)
-		result = nil
-		chanLock.Unlock()
+		if err := <-listed; err != nil && ctx.Err() == nil {
+			log.WithError(err).Warn("Failed to list resource, retrying in the background")
+		}
+		if w.starting != nil {
+			<-w.starting
+		}
 	}()
+	i.Run(ctx.Done())
+}
 
-	w.dynamic.OnChange(ctx, "watcher", w.shouldWatch, func(obj runtime.Object) (runtime.Object, error) {
-		chanLock.Lock()
-		defer chanLock.Unlock()
-		if obj != nil && result != nil {
-			result <- obj
+// initialList sets the watch error handler of i to onError, and returns a
+// channel receiving nil once i has synced, or the first error of its
+// initial list, or the error of ctx when it is done first. It must be called
+// before i is run.
+func initialList(ctx context.Context, i cache.SharedIndexInformer, onError func(err error)) <-chan error {
+	failed := make(chan error, 1)
+	_ = i.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		onError(err)
+		if !i.HasSynced() {
+			select {
+			case failed <- err:
+			default:
+			}
 		}
-		return obj, nil
 	})
 
-	return result, nil
+	result := make(chan error, 1)
+	go func() {
+		err := wait.PollImmediateUntil(100*time.Millisecond, func() (bool, error) {
+			select {
+			case err := <-failed:
+				return false, err
+			default:
+				return i.HasSynced(), nil
+			}
+		}, ctx.Done())
+		if err == wait.ErrWaitTimeout {
+			err = ctx.Err()
+		}
+		result <- err
+	}()
+	return result
 }
 
-func (w *Watcher) MatchName(name string) {
-	w.matchers = append(w.matchers, func(gvk schema.GroupVersionKind) bool {
-		return w.isName(name, gvk)
+// MatchName adds a resource to watch, given kubectl style as a resource
+// name optionally followed by a glob on the object names, e.g. pods/nginx-*.
+func (w *Watcher) MatchName(name string) error {
+	resource, pattern := kv.Split(name, "/")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	w.matchers = append(w.matchers, matcher{
+		resource: resource,
+		pattern:  pattern,
 	})
+	return nil
 }
 
-func (w *Watcher) isName(name string, gvk schema.GroupVersionKind) bool {
-	mapping, err := w.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+// SetNameRegex only watches the objects whose name matches expr.
+func (w *Watcher) SetNameRegex(expr string) error {
+	if expr == "" {
+		w.nameRegex = nil
+		return nil
+	}
+	re, err := regexp.Compile(expr)
 	if err != nil {
-		return false
+		return err
 	}
+	w.nameRegex = re
+	return nil
+}
+
+// matches returns whether m selects r.
+func (m matcher) matches(r resource) bool {
+	if m.wildcard() {
+		return isName(m.resource, r)
+	}
+	return m.resources[r.gvr]
+}
 
+// isName matches r against a resource name or lowercase kind followed by
+// an exact group, where the name * matches every resource in the group.
+func isName(name string, r resource) bool {
 	resource, group := kv.Split(name, ".")
-	return (resource == "*" || mapping.Resource.Resource == resource || strings.ToLower(gvk.Kind) == resource) &&
-		gvk.Group == group
+	return (resource == "*" || r.gvr.Resource == resource || strings.ToLower(r.gvk.Kind) == resource) &&
+		r.gvk.Group == group
 }